@@ -0,0 +1,17 @@
+package common
+
+// TrafficStat 按天和维度汇总的访问来源统计
+type TrafficStat struct {
+	Date      string `gorm:"primaryKey" json:"date"`
+	Dimension string `gorm:"primaryKey" json:"dimension"` // 统计维度，见 ANALYTICS_DIMENSION_*
+	Value     string `gorm:"primaryKey" json:"value"`
+	Hits      int64  `json:"hits"`
+	Bandwidth int64  `json:"bandwidth"`
+}
+
+// AnalyticsItem 某个维度下的一项排行
+type AnalyticsItem struct {
+	Value     string `json:"value"`
+	Hits      int64  `json:"hits"`
+	Bandwidth int64  `json:"bandwidth"`
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// APIToken 供脚本和桌面工具使用的长期令牌，数据库中只保存令牌的哈希
+type APIToken struct {
+	ID int64 `gorm:"primaryKey" json:"id"`
+
+	Name         string `json:"name"`
+	TokenHash    string `gorm:"uniqueIndex" json:"-"` // 令牌明文的 SHA256
+	Prefix       string `json:"prefix"`               // 令牌开头几位，用于辨认
+	Scopes       string `json:"-"`                    // 逗号分隔的权限范围
+	AllowIPs     string `json:"-"`                    // 逗号分隔的 IP 或 CIDR，空为不限制
+	ExpireTime   int64  `json:"expire_time"`          // 过期时间(秒)，0 为永不过期
+	Revoked      bool   `json:"revoked"`
+	LastUsedTime int64  `json:"last_used_time"`
+	LastUsedIP   string `json:"last_used_ip"`
+
+	CreateTime int64 `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime int64 `gorm:"autoUpdateTime" json:"update_time"`
+
+	Token       string   `gorm:"-:all" json:"token,omitempty"` // 令牌明文，仅在创建时返回
+	ScopeList   []string `gorm:"-:all" json:"scopes"`
+	AllowIPList []string `gorm:"-:all" json:"allow_ips"`
+}
+
+var apiTokenScopes = []string{API_TOKEN_SCOPE_UPLOAD, API_TOKEN_SCOPE_READ, API_TOKEN_SCOPE_DELETE, API_TOKEN_SCOPE_ADMIN}
+
+func (t *APIToken) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(t.ScopeList) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range t.ScopeList {
+		if !slices.Contains(apiTokenScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	for _, ip := range t.AllowIPList {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return fmt.Errorf("invalid ip or cidr %q", ip)
+			}
+		}
+	}
+	return nil
+}
+
+// HasScope 判断令牌是否拥有指定权限，admin 拥有全部权限
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.ScopeList, scope) || slices.Contains(t.ScopeList, API_TOKEN_SCOPE_ADMIN)
+}
+
+// AllowIP 判断客户端地址是否在允许列表中
+func (t *APIToken) AllowIP(ip string) bool {
+	if len(t.AllowIPList) == 0 {
+		return true
+	}
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return false
+	}
+	for _, allow := range t.AllowIPList {
+		if allowIP := net.ParseIP(allow); allowIP != nil {
+			if allowIP.Equal(clientIP) {
+				return true
+			}
+		} else if _, n, err := net.ParseCIDR(allow); err == nil && n.Contains(clientIP) {
+			return true
+		}
+	}
+	return false
+}
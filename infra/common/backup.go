@@ -1,8 +1,9 @@
 package common
 
 type BackupFormat struct {
-	Version   int         `json:"version"`
-	Images    []Image     `json:"images"`
-	ImageTags []ImageTags `json:"image_tags"`
-	Settings  []Setting   `json:"settings"`
+	Version       int            `json:"version"`
+	Images        []Image        `json:"images"`
+	ImageTags     []ImageTags    `json:"image_tags"`
+	ImageVariants []ImageVariant `json:"image_variants"`
+	Settings      []Setting      `json:"settings"`
 }
@@ -0,0 +1,17 @@
+package common
+
+// BatchUploadOptions 批量上传的公共参数，对批次中的每个文件生效
+type BatchUploadOptions struct {
+	Remark   string
+	Tags     []string
+	Private  bool
+	PathTags bool // 将压缩包内的目录名作为标签
+}
+
+// BatchUploadResult 批量上传中单个文件的处理结果
+type BatchUploadResult struct {
+	Name   string `json:"name"`   // 文件名，压缩包中的文件为 "压缩包名/包内路径"
+	Status string `json:"status"` // created、deduplicated 或 rejected
+	Reason string `json:"reason,omitempty"`
+	Image  *Image `json:"image,omitempty"`
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+)
+
+// CachePolicy 图片响应的缓存头规则，按顺序匹配，第一条命中的规则生效
+type CachePolicy struct {
+	Rules []CacheRule `json:"rules"`
+}
+
+// CacheRule 单条缓存规则，匹配条件为空表示不限制
+type CacheRule struct {
+	Tags         []string `json:"tags"`          // 图片带有其中任一标签时匹配
+	ContentTypes []string `json:"content_types"` // 实际发送的内容类型，支持 image/* 形式
+	Visibility   string   `json:"visibility"`    // public/private，空为不限
+
+	MaxAge               int    `json:"max_age"`                // 浏览器缓存时间(秒)
+	SMaxAge              int    `json:"s_maxage"`               // 共享缓存(CDN)的缓存时间(秒)，0 为不设置
+	StaleWhileRevalidate int    `json:"stale_while_revalidate"` // 过期后仍可使用旧内容的时间(秒)，0 为不设置
+	Immutable            bool   `json:"immutable"`              // 内容不会变化，缓存期内不再验证
+	NoStore              bool   `json:"no_store"`               // 禁止任何缓存，优先于其它缓存设置
+	Disposition          string `json:"disposition"`            // inline/attachment，空为不设置
+}
+
+func (p *CachePolicy) Validate() error {
+	for i, rule := range p.Rules {
+		if rule.MaxAge < 0 || rule.SMaxAge < 0 || rule.StaleWhileRevalidate < 0 {
+			return fmt.Errorf("rule %d: cache time must not be negative", i+1)
+		}
+		if !slices.Contains([]string{"", IMAGE_VISIBILITY_PUBLIC, IMAGE_VISIBILITY_PRIVATE}, rule.Visibility) {
+			return fmt.Errorf("rule %d: invalid visibility %q", i+1, rule.Visibility)
+		}
+		if !slices.Contains([]string{"", CONTENT_DISPOSITION_INLINE, CONTENT_DISPOSITION_ATTACHMENT}, rule.Disposition) {
+			return fmt.Errorf("rule %d: invalid disposition %q", i+1, rule.Disposition)
+		}
+	}
+	return nil
+}
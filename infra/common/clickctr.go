@@ -6,4 +6,8 @@ type ClickCtrData struct {
 	TotalBandwidth   int64 `json:"total_bandwidth"`
 	MonthlyClick     int64 `json:"monthly_click"`
 	MonthlyBandwidth int64 `json:"monthly_bandwidth"`
+	TotalBlocked     int64 `json:"total_blocked"`
+	MonthlyBlocked   int64 `json:"monthly_blocked"`
+	TotalLimited     int64 `json:"total_limited"`
+	MonthlyLimited   int64 `json:"monthly_limited"`
 }
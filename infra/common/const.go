@@ -1,8 +1,14 @@
 package common
 
+import "time"
+
 const (
 	ENTITY_TYPE_FILE   = 1
 	ENTITY_TYPE_FOLDER = 2
+	ENTITY_TYPE_SHARE  = 3
+
+	SHARE_TARGET_IMAGE = 1
+	SHARE_TARGET_TAG   = 2
 
 	S3TASK_ACTION_UPLOAD = 1
 	S3TASK_ACTION_DELETE = 2
@@ -11,6 +17,55 @@ const (
 	S3TASK_STATUS_RUNNING = 1
 	S3TASK_STATUS_SUCCESS = 2
 	S3TASK_STATUS_FAILED  = 3
+
+	VARIANT_JOB_STATUS_RUNNING  = "running"
+	VARIANT_JOB_STATUS_PAUSED   = "paused"
+	VARIANT_JOB_STATUS_FINISHED = "finished"
+	VARIANT_JOB_STATUS_FAILED   = "failed"
+
+	BATCH_STATUS_CREATED      = "created"
+	BATCH_STATUS_DEDUPLICATED = "deduplicated"
+	BATCH_STATUS_REJECTED     = "rejected"
+
+	API_TOKEN_SCOPE_UPLOAD = "upload"
+	API_TOKEN_SCOPE_READ   = "read"
+	API_TOKEN_SCOPE_DELETE = "delete"
+	API_TOKEN_SCOPE_ADMIN  = "admin"
+
+	HOTLINK_ACTION_FORBIDDEN   = "forbidden"
+	HOTLINK_ACTION_PLACEHOLDER = "placeholder"
+	HOTLINK_ACTION_REDIRECT    = "redirect"
+
+	IMAGE_SORT_NEWEST        = "newest"
+	IMAGE_SORT_OLDEST        = "oldest"
+	IMAGE_SORT_VIEWS         = "views"
+	IMAGE_SORT_BANDWIDTH     = "bandwidth"
+	IMAGE_SORT_LAST_ACCESSED = "last_accessed"
+
+	STAT_GRANULARITY_DAY   = "day"
+	STAT_GRANULARITY_WEEK  = "week"
+	STAT_GRANULARITY_MONTH = "month"
+
+	STAT_DATE_FORMAT = "2006-01-02"
+
+	IMAGE_VISIBILITY_PUBLIC  = "public"
+	IMAGE_VISIBILITY_PRIVATE = "private"
+
+	IMAGE_ORIENTATION_LANDSCAPE = "landscape"
+	IMAGE_ORIENTATION_PORTRAIT  = "portrait"
+	IMAGE_ORIENTATION_SQUARE    = "square"
+
+	CONTENT_DISPOSITION_INLINE     = "inline"
+	CONTENT_DISPOSITION_ATTACHMENT = "attachment"
+
+	ANALYTICS_DIMENSION_REFERER = "referer"
+	ANALYTICS_DIMENSION_CLIENT  = "client"
+	ANALYTICS_DIMENSION_FORMAT  = "format"
+	ANALYTICS_DIMENSION_COUNTRY = "country"
+
+	ANALYTICS_VALUE_DIRECT  = "(direct)"
+	ANALYTICS_VALUE_UNKNOWN = "(unknown)"
+	ANALYTICS_VALUE_OTHER   = "(other)"
 )
 
 const (
@@ -23,16 +78,68 @@ const (
 	SETTING_KEY_AUTO_CONV_WEBP     = "auto_conv_webp"
 	SETTING_KEY_AUTO_CONV_AVIF     = "auto_conv_avif"
 	SETTING_KEY_CLICK_CTR_DATA     = "click_ctr_data"
+	SETTING_KEY_UPLOAD_POLICY      = "upload_policy"
+	SETTING_KEY_VARIANT_JOB        = "variant_job"
+	SETTING_KEY_VARIANT_MIN_SAVING = "variant_min_saving"
+	SETTING_KEY_STAT_RETENTION_DAY = "stat_retention_day"
+	SETTING_KEY_HOTLINK_POLICY     = "hotlink_policy"
+	SETTING_KEY_DELIVERY_LIMIT     = "delivery_limit"
+	SETTING_KEY_CACHE_POLICY       = "cache_policy"
+	SETTING_KEY_LINK_FORMAT        = "link_format"
+	SETTING_KEY_GALLERY            = "gallery"
 )
 
 const (
 	DEFAULT_ADMIN_USER = "admin"
 
 	MAX_IMAGE_SIZE = 50 * 1024 * 1024
+	MAX_BODY_SIZE  = 200 * 1024 * 1024 // 请求体大小上限，批量上传时可以包含多个文件
 
 	AUTO_BACKUP_PREFIX  = "auto-"
-	BACKUP_FILE_VERSION = 1
+	BACKUP_FILE_VERSION = 2
+
+	DEFAULT_VARIANT_MIN_SAVING = 5   // 衍生版本至少比原图小 5% 才会被使用
+	DEFAULT_STAT_RETENTION_DAY = 730 // 每日统计默认保留两年
+
+	SHARE_IMAGE_URL_EXPIRE = 3600 // 分享页中图片签名链接的有效期(秒)
+
+	IMAGE_MISS_CACHE_SIZE = 4 * 1024 * 1024 // 不存在图片 ID 缓存的容量(字节)
+	IMAGE_MISS_CACHE_TTL  = 10 * time.Minute
+	IMAGE_TAG_CACHE_SIZE  = 4 * 1024 * 1024 // 图片标签缓存的容量(字节)
+	IMAGE_TAG_CACHE_TTL   = 10 * time.Minute
+
+	RANDOM_CACHE_TTL         = time.Minute // 随机图片候选 ID 的缓存时间
+	RANDOM_CACHE_MAX_FILTERS = 64          // 最多缓存的筛选条件组合数，超出时整体清空
+
+	ANALYTICS_MAX_REFERERS = 1000 // 每天最多记录的来源域名数，超出的计入 (other)
+
+	MEMORY_CACHE_MAX_OBJECT_SIZE = 512 * 1024 // 只有不超过此大小的文件会放入内存缓存
+
+	DEFAULT_CACHE_MAX_AGE = 30 * 24 * 3600 // 没有匹配的缓存规则时，公开图片缓存30天
+
+	IMAGE_SIZE_UNREADABLE = -1 // 无法读取尺寸的图片(如 SVG、损坏的文件)记录的宽高，避免反复读取
+
+	TUS_VERSION       = "1.0.0"
+	TUS_UPLOAD_EXPIRE = 24 * time.Hour // 未完成的断点续传上传保留时间
+
+	FETCH_MAX_URLS      = 20 // 单次远程抓取最多的地址数
+	FETCH_MAX_REDIRECTS = 5
+	FETCH_TIMEOUT       = 60 * time.Second
+	FETCH_WORKERS       = 4               // 远程抓取的并发数
+	FETCH_BATCH_TIMEOUT = 3 * time.Minute // 单次远程抓取请求的总超时
+
+	BATCH_MAX_FILES        = 1000                   // 单次批量上传最多处理的文件数(含压缩包内的文件)
+	BATCH_MAX_EXTRACT_SIZE = 2 * 1024 * 1024 * 1024 // 单次批量上传解压出的总大小上限
+
+	API_TOKEN_PREFIX         = "mmk_" // API 令牌的固定前缀，用于和登录令牌区分
+	API_TOKEN_TOUCH_INTERVAL = 60     // 最后使用时间的更新间隔(秒)，避免每个请求都写数据库
+
+	DEFAULT_GALLERY_PAGE_SIZE = 24
+	GALLERY_FEED_SIZE         = 20  // 订阅源中的最新图片数
+	GALLERY_THUMB_WIDTH       = 400 // 画廊缩略图宽度
 
 	IMAGE_TYPE_WEBP = "image/webp"
 	IMAGE_TYPE_AVIF = "image/avif"
+	IMAGE_TYPE_JPEG = "image/jpeg"
+	IMAGE_TYPE_PNG  = "image/png"
 )
@@ -0,0 +1,22 @@
+package common
+
+// DailyStat 按天汇总的统计数据
+type DailyStat struct {
+	Date string `gorm:"primaryKey" json:"date"` // 日期，格式为 2006-01-02
+
+	Hits          int64 `json:"hits"`
+	Bandwidth     int64 `json:"bandwidth"`
+	Uploads       int64 `json:"uploads"`
+	StorageGrowth int64 `json:"storage_growth"` // 新增图片体积减去删除图片体积
+	Conversions   int64 `json:"conversions"`
+}
+
+// StatPoint 统计历史中的一个时间段
+type StatPoint struct {
+	Period        string `json:"period"` // 时间段的起始日期
+	Hits          int64  `json:"hits"`
+	Bandwidth     int64  `json:"bandwidth"`
+	Uploads       int64  `json:"uploads"`
+	StorageGrowth int64  `json:"storage_growth"`
+	Conversions   int64  `json:"conversions"`
+}
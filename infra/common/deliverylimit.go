@@ -0,0 +1,56 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DeliveryLimitPolicy 图片分发的限流策略，按客户端 IP 统计
+type DeliveryLimitPolicy struct {
+	Enabled         bool     `json:"enabled"`
+	RequestRate     float64  `json:"request_rate"`     // 每个 IP 每秒允许的请求数，0 为不限制
+	RequestBurst    int      `json:"request_burst"`    // 允许的突发请求数，0 为一秒的请求数
+	IPBandwidth     int64    `json:"ip_bandwidth"`     // 每个 IP 的带宽上限(字节/秒)，0 为不限制
+	GlobalBandwidth int64    `json:"global_bandwidth"` // 全局带宽上限(字节/秒)，0 为不限制
+	BandwidthBurst  int64    `json:"bandwidth_burst"`  // 允许的突发字节数，0 为一秒的流量
+	Allow           []string `json:"allow"`            // 不受限制的 IP 或 CIDR 网段
+	MaxS3Downloads  int      `json:"max_s3_downloads"` // 缓存未命中时同时从 S3 下载的最大数量，0 为不限制
+}
+
+func (p *DeliveryLimitPolicy) Validate() error {
+	if p.RequestRate < 0 || p.RequestBurst < 0 || p.IPBandwidth < 0 || p.GlobalBandwidth < 0 || p.BandwidthBurst < 0 || p.MaxS3Downloads < 0 {
+		return fmt.Errorf("limit values must not be negative")
+	}
+	_, err := p.AllowNets()
+	return err
+}
+
+// AllowNets 解析白名单，单个 IP 视为只包含自身的网段
+func (p *DeliveryLimitPolicy) AllowNets() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(p.Allow))
+	for _, item := range p.Allow {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
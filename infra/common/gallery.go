@@ -0,0 +1,47 @@
+package common
+
+import "fmt"
+
+// GalleryPolicy 公开画廊设置，只有列出的标签会公开浏览
+type GalleryPolicy struct {
+	Tags     []GalleryTag `json:"tags"`      // 公开的标签，为空时不开放画廊
+	PageSize int          `json:"page_size"` // 每页图片数，0 为默认值
+}
+
+// GalleryTag 公开的标签，标签下的私有图片不会出现在画廊中
+type GalleryTag struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"` // 显示名称，空为标签名
+	Description string `json:"description"`
+}
+
+// GalleryTagCount 公开标签及其公开图片数量
+type GalleryTagCount struct {
+	GalleryTag
+	Count int64 `json:"count"`
+}
+
+func (p *GalleryPolicy) Validate() error {
+	names := make(map[string]bool)
+	for i, tag := range p.Tags {
+		if tag.Name == "" {
+			return fmt.Errorf("tag %d: name is required", i+1)
+		}
+		if names[tag.Name] {
+			return fmt.Errorf("tag %d: duplicate name %q", i+1, tag.Name)
+		}
+		names[tag.Name] = true
+	}
+	if p.PageSize < 0 || p.PageSize > 100 {
+		return fmt.Errorf("page size must be between 0 and 100")
+	}
+	return nil
+}
+
+// DisplayTitle 画廊中显示的名称
+func (t GalleryTag) DisplayTitle() string {
+	if t.Title != "" {
+		return t.Title
+	}
+	return t.Name
+}
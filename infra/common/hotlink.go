@@ -0,0 +1,11 @@
+package common
+
+// HotlinkPolicy 防盗链策略，按 Referer 主机过滤图片请求
+type HotlinkPolicy struct {
+	Enabled     bool     `json:"enabled"`
+	Allow       []string `json:"allow"`        // 允许的 Referer 主机，支持 *.example.com 形式的通配，空为不限
+	Deny        []string `json:"deny"`         // 拒绝的 Referer 主机，优先于 Allow
+	AllowEmpty  bool     `json:"allow_empty"`  // 是否允许没有 Referer 的请求
+	Action      string   `json:"action"`       // 拦截后的响应方式：forbidden/placeholder/redirect
+	RedirectURL string   `json:"redirect_url"` // Action 为 redirect 时跳转的地址
+}
@@ -8,7 +8,13 @@ type Image struct {
 	ContentType string `json:"content_type"`
 	Hash        string `gorm:"uniqueIndex" json:"hash"`
 	FileSize    int64  `json:"file_size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
 	Remark      string `gorm:"type:text" json:"remark"`
+	Private     bool   `json:"private"` // 私有图片仅能通过签名链接或管理员令牌访问
+
+	OriginalPath string `json:"original_path,omitempty"` // 上传策略处理前的原图归档路径
+	SourceURL    string `json:"source_url,omitempty"`    // 从远程地址抓取时的来源地址
 
 	CreateTime int64 `gorm:"autoCreateTime" json:"create_time"`
 	UpdateTime int64 `gorm:"autoUpdateTime" json:"update_time"`
@@ -17,4 +23,9 @@ type Image struct {
 	LocalPath  string   `gorm:"-:all" json:"local_path,omitempty"`
 	RemotePath string   `gorm:"-:all" json:"remote_path,omitempty"`
 	Tags       []string `gorm:"-:all" json:"tags,omitempty"`
+	Links      []Link   `gorm:"-:all" json:"links,omitempty"` // 按链接格式设置生成的引用代码
+
+	Views        int64 `gorm:"-:all" json:"views"`
+	Bandwidth    int64 `gorm:"-:all" json:"bandwidth"`
+	LastAccessed int64 `gorm:"-:all" json:"last_accessed"`
 }
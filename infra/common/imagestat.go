@@ -0,0 +1,9 @@
+package common
+
+// ImageStat 单张图片的访问统计，由内存计数定期刷入
+type ImageStat struct {
+	ImageID      int64 `gorm:"primaryKey;autoIncrement:false" json:"image_id"`
+	Views        int64 `json:"views"`
+	Bandwidth    int64 `json:"bandwidth"`
+	LastAccessed int64 `json:"last_accessed"`
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+// LinkFormatPolicy 图片链接格式设置，模板使用 Go text/template 语法
+type LinkFormatPolicy struct {
+	Formats      []LinkFormat `json:"formats"`       // 链接格式列表，为空时使用内置格式
+	SrcsetWidths []int        `json:"srcset_widths"` // srcset 使用的宽度列表，为空时不生成 srcset
+	WidthURL     string       `json:"width_url"`     // 指定宽度的图片地址模板，可用 .URL 和 .Width，需配合支持缩放的 CDN
+}
+
+// LinkFormat 单个链接格式，模板中可用的字段见 LinkData
+type LinkFormat struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+}
+
+// LinkData 渲染链接格式模板时使用的数据
+type LinkData struct {
+	URL     string       // 图片地址
+	PageURL string       // 图片查看页地址
+	Name    string       // 图片名称
+	Width   int          // 原图宽度
+	Height  int          // 原图高度
+	Srcset  string       // 原格式按宽度生成的 srcset，未配置宽度时为空
+	Sources []LinkSource // 可用的其它格式，用于 <picture> 的 <source>
+}
+
+// LinkSource 图片的其它格式版本
+type LinkSource struct {
+	Type   string // 内容类型，如 image/avif
+	URL    string
+	Srcset string // 按宽度生成的 srcset，未配置宽度时为 URL
+}
+
+func (p *LinkFormatPolicy) Validate() error {
+	names := make(map[string]bool)
+	for i, format := range p.Formats {
+		if format.Name == "" {
+			return fmt.Errorf("format %d: name is required", i+1)
+		}
+		if names[format.Name] {
+			return fmt.Errorf("format %d: duplicate name %q", i+1, format.Name)
+		}
+		names[format.Name] = true
+		if _, err := template.New(format.Name).Parse(format.Template); err != nil {
+			return fmt.Errorf("format %d: %w", i+1, err)
+		}
+	}
+	for _, width := range p.SrcsetWidths {
+		if width <= 0 {
+			return errors.New("srcset width must be positive")
+		}
+	}
+	if len(p.SrcsetWidths) > 0 {
+		if p.WidthURL == "" {
+			return errors.New("width_url is required when srcset_widths is set")
+		}
+		if _, err := template.New("width_url").Parse(p.WidthURL); err != nil {
+			return fmt.Errorf("width_url: %w", err)
+		}
+	}
+	return nil
+}
+
+// Link 生成的图片引用代码
+type Link struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
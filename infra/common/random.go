@@ -0,0 +1,8 @@
+package common
+
+// RandomFilter 随机图片的筛选条件，为空表示不限制
+type RandomFilter struct {
+	Tags        []string // 带有其中任一标签
+	Visibility  string   // public/private
+	Orientation string   // landscape/portrait/square
+}
@@ -0,0 +1,23 @@
+package common
+
+// ShareLink 分享链接，指向单张图片或一个标签下的全部图片
+type ShareLink struct {
+	ID int64 `gorm:"primaryKey" json:"id"`
+
+	TargetType int32  `json:"target_type"`
+	ImageID    int64  `json:"image_id,omitempty"`
+	TagName    string `json:"tag_name,omitempty"`
+	Password   string `json:"-"`           // bcrypt 哈希，空为无需密码
+	ExpireTime int64  `json:"expire_time"` // 过期时间(秒)，0 为永不过期
+	MaxViews   int64  `json:"max_views"`   // 最大访问次数，0 为不限，1 为阅后即焚
+	Views      int64  `json:"views"`
+	Revoked    bool   `json:"revoked"`
+	Remark     string `json:"remark"`
+
+	CreateTime int64 `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime int64 `gorm:"autoUpdateTime" json:"update_time"`
+
+	Code        string `gorm:"-:all" json:"code,omitempty"`
+	URL         string `gorm:"-:all" json:"url,omitempty"`
+	HasPassword bool   `gorm:"-:all" json:"has_password"`
+}
@@ -0,0 +1,13 @@
+package common
+
+// TusUpload 断点续传上传的状态，以 JSON 保存在数据目录中
+type TusUpload struct {
+	ID         string            `json:"id"`
+	Length     int64             `json:"length"`
+	Offset     int64             `json:"offset"`
+	Metadata   map[string]string `json:"metadata"`
+	RawMeta    string            `json:"raw_meta"` // 原始的 Upload-Metadata，HEAD 请求时原样返回
+	CreateTime int64             `json:"create_time"`
+	ExpireTime int64             `json:"expire_time"`
+	ImageID    int64             `json:"image_id,omitempty"` // 上传完成并入库后的图片 ID
+}
@@ -0,0 +1,23 @@
+package common
+
+// UploadPolicy 上传入库策略，在计算哈希之前应用
+type UploadPolicy struct {
+	MaxWidth      int    `json:"max_width"`      // 超过此宽度的图片会被等比缩小，0 为不限制
+	MaxHeight     int    `json:"max_height"`     // 超过此高度的图片会被等比缩小，0 为不限制
+	Optimize      bool   `json:"optimize"`       // 无损重压缩 PNG/JPEG，JPEG 只去掉元数据并重建 Huffman 表，仅在体积变小时采用
+	ConvertFormat string `json:"convert_format"` // 统一转换的目标格式(webp/avif/jpeg/png)，空为保持原格式
+	KeepOriginal  bool   `json:"keep_original"`  // 图片被修改时，保留未处理的原图作为私有归档
+}
+
+// UploadFile 待入库的上传文件
+type UploadFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	Remark      string
+	Tags        []string
+	Private     bool
+	SourceURL   string // 远程抓取的来源地址
+	// 内容与已有私有图片相同时拒绝上传，不修改该图片，用于只返回公开地址的接口
+	RejectPrivateDuplicate bool
+}
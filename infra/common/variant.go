@@ -0,0 +1,17 @@
+package common
+
+// ImageVariant 图片的转换衍生版本，持久化在对象存储中
+type ImageVariant struct {
+	ID int64 `gorm:"primaryKey" json:"id"`
+
+	ImageID      int64  `gorm:"uniqueIndex:idx_image_variant" json:"image_id"`
+	Format       string `gorm:"uniqueIndex:idx_image_variant" json:"format"`        // 内容类型，如 image/webp
+	TransformKey string `gorm:"uniqueIndex:idx_image_variant" json:"transform_key"` // 变换参数，空为仅做格式转换
+	FileSize     int64  `json:"file_size"`
+	Hash         string `json:"hash"`
+	RemotePath   string `json:"remote_path"`
+	Discarded    bool   `json:"discarded"` // 转换结果没有比原图小，不再使用该衍生版本
+
+	CreateTime int64 `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime int64 `gorm:"autoUpdateTime" json:"update_time"`
+}
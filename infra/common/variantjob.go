@@ -0,0 +1,25 @@
+package common
+
+// VariantJobFilter 批量生成衍生版本任务的筛选条件
+type VariantJobFilter struct {
+	Tags       []string `json:"tags"`        // 包含任一标签的图片，空为不限
+	StartTime  int64    `json:"start_time"`  // 上传时间下限(秒)，0 为不限
+	EndTime    int64    `json:"end_time"`    // 上传时间上限(秒)，0 为不限
+	Formats    []string `json:"formats"`     // 需要生成的格式，空为当前启用的自动转换格式
+	Force      bool     `json:"force"`       // 已存在的衍生版本也重新生成
+	IntervalMs int      `json:"interval_ms"` // 每张图片处理后的等待时间，用于限流
+}
+
+// VariantJobState 批量生成衍生版本任务的进度，持久化后可在重启后继续
+type VariantJobState struct {
+	Status    string           `json:"status"`
+	Filter    VariantJobFilter `json:"filter"`
+	Total     int64            `json:"total"`
+	Processed int64            `json:"processed"`
+	Converted int64            `json:"converted"`
+	Failed    int64            `json:"failed"`
+	LastID    int64            `json:"last_id"`
+	StartTime int64            `json:"start_time"`
+	EndTime   int64            `json:"end_time"`
+	Error     string           `json:"error,omitempty"`
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "momoka"
+
+// Registry 所有指标注册在独立的 Registry 中，避免引入默认注册表中的全局指标
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	ImageHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_hits_total",
+		Help:      "Image requests served.",
+	})
+	ImageBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_sent_bytes_total",
+		Help:      "Image bytes sent to clients.",
+	})
+	ImageCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_cache_requests_total",
+		Help:      "Local cache lookups for images, by result (hit or miss).",
+	}, []string{"result"})
+	MemoryCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "memory_cache_requests_total",
+		Help:      "In-memory cache lookups for cacheable image files, by result (hit or miss).",
+	}, []string{"result"})
+	ImageFallbackDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_fallback_downloads_total",
+		Help:      "Images downloaded from S3 on cache miss, by result (success or error).",
+	}, []string{"result"})
+
+	LimitHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limit_hits_total",
+		Help:      "Image requests hitting delivery limits, by type (request, bandwidth or s3_download).",
+	}, []string{"type"})
+
+	S3Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_operation_duration_seconds",
+		Help:      "S3 operation latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	S3Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_operation_errors_total",
+		Help:      "Failed S3 operations by operation.",
+	}, []string{"operation"})
+	S3Tasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "s3_tasks",
+		Help:      "S3 tasks in the queue by status.",
+	}, []string{"status"})
+
+	ConverterQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "converter_queue_length",
+		Help:      "Pending background image conversions.",
+	})
+	ConvertDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "convert_duration_seconds",
+		Help:      "Image encode duration by target format.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"format"})
+
+	CacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size_bytes",
+		Help:      "Size of the local image cache.",
+	})
+	CacheFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_files",
+		Help:      "Number of files in the local image cache.",
+	})
+	MemoryCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_cache_size_bytes",
+		Help:      "Size of the in-memory hot object cache.",
+	})
+	DatabaseSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "database_size_bytes",
+		Help:      "Size of the SQLite database including its WAL file.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration,
+		ImageHits, ImageBytes, ImageCache, MemoryCache, ImageFallbackDownloads, LimitHits,
+		S3Duration, S3Errors, S3Tasks,
+		ConverterQueue, ConvertDuration,
+		CacheSize, CacheFiles, MemoryCacheSize, DatabaseSize,
+	)
+}
+
+// ObserveS3 记录一次 S3 操作的耗时和结果，配合 defer 和命名返回值使用
+func ObserveS3(operation string, start time.Time, err *error) {
+	S3Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		S3Errors.WithLabelValues(operation).Inc()
+	}
+}
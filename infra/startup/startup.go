@@ -10,6 +10,7 @@ import (
 
 	_ "github.com/joho/godotenv/autoload"
 	gorm_logrus "github.com/onrik/gorm-logrus"
+	"github.com/oschwald/maxminddb-golang"
 	"github.com/sirupsen/logrus"
 	"github.com/speps/go-hashids"
 	"github.com/zjyl1994/cap-go"
@@ -52,6 +53,18 @@ func Startup() (err error) {
 	vars.S3Debug, _ = strconv.ParseBool(os.Getenv("MOMOKA_S3_DEBUG"))
 
 	vars.ListenAddr = utils.COALESCE(os.Getenv("MOMOKA_LISTEN_ADDR"), ":8080")
+	// 设置任意一项即启用 Prometheus 指标
+	vars.MetricsToken = os.Getenv("MOMOKA_METRICS_TOKEN")
+	vars.MetricsListenAddr = os.Getenv("MOMOKA_METRICS_LISTEN_ADDR")
+	// 远程抓取默认禁止访问内网地址，仅在可信的内网部署中开启
+	vars.FetchAllowPrivate, _ = strconv.ParseBool(os.Getenv("MOMOKA_FETCH_ALLOW_PRIVATE"))
+	// 客户端 IP 只在请求来自可信代理时从代理头读取，默认只信任本机的反向代理
+	for _, proxy := range strings.Split(utils.COALESCE(os.Getenv("MOMOKA_TRUSTED_PROXIES"), "127.0.0.1,::1"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			vars.TrustedProxies = append(vars.TrustedProxies, proxy)
+		}
+	}
+	vars.ProxyHeader = utils.COALESCE(os.Getenv("MOMOKA_PROXY_HEADER"), "X-Real-IP")
 
 	vars.AutoCleanDays, err = strconv.Atoi(utils.COALESCE(os.Getenv("MOMOKA_AUTO_CLEAN_DAYS"), "7"))
 	if err != nil {
@@ -61,9 +74,23 @@ func Startup() (err error) {
 	if err != nil {
 		return err
 	}
+	// 内存热点缓存大小(MB)，0 为不启用
+	memoryCacheSize, err := strconv.ParseInt(utils.COALESCE(os.Getenv("MOMOKA_MEMORY_CACHE_SIZE"), "0"), 10, 64)
+	if err != nil {
+		return err
+	}
+	service.CacheService.Init(memoryCacheSize * 1024 * 1024)
+
+	if geoIPPath := os.Getenv("MOMOKA_GEOIP_DB"); geoIPPath != "" {
+		// 可选的 GeoLite 国家数据库，用于统计访问来源国家
+		vars.GeoIPReader, err = maxminddb.Open(geoIPPath)
+		if err != nil {
+			return err
+		}
+	}
 
 	vars.CapInstance = cap.NewCap(utils.NewFreeCacheStorage(100 * 1024))
-	vars.ImageConverter = utils.NewImageConverter()
+	vars.ImageConverter = utils.NewImageConverter(service.ImageVariantService.OnConverted)
 
 	vars.DataPath = os.Getenv("MOMOKA_DATA_PATH")
 	err = os.MkdirAll(vars.DataPath, 0755)
@@ -83,7 +110,7 @@ func Startup() (err error) {
 		return err
 	}
 
-	err = vars.Database.AutoMigrate(&common.Setting{}, &common.S3Task{}, &common.Image{}, &common.ImageTags{})
+	err = vars.Database.AutoMigrate(&common.Setting{}, &common.S3Task{}, &common.Image{}, &common.ImageTags{}, &common.ImageVariant{}, &common.ShareLink{}, &common.APIToken{}, &common.ImageStat{}, &common.DailyStat{}, &common.TrafficStat{})
 	if err != nil {
 		return err
 	}
@@ -170,6 +197,22 @@ func Startup() (err error) {
 		vars.AutoConvFormat = append(vars.AutoConvFormat, common.IMAGE_TYPE_AVIF)
 	}
 	logrus.Debugln("Auto convert format: ", vars.AutoConvFormat)
+	// load variant min saving
+	variantMinSaving, err := service.SettingService.Get(common.SETTING_KEY_VARIANT_MIN_SAVING)
+	if err != nil {
+		return err
+	}
+	vars.VariantMinSaving = service.ParseVariantMinSaving(variantMinSaving)
+	// load stat retention day
+	statRetentionDay, err := service.SettingService.Get(common.SETTING_KEY_STAT_RETENTION_DAY)
+	if err != nil {
+		return err
+	}
+	vars.StatRetentionDay = service.ParseStatRetentionDay(statRetentionDay)
+	// load json settings
+	if err = service.SettingService.LoadJSONSettings(); err != nil {
+		return err
+	}
 	// load click counter data
 	clickCtrJson, err := service.SettingService.Get(common.SETTING_KEY_CLICK_CTR_DATA)
 	if err != nil {
@@ -199,10 +242,28 @@ func Startup() (err error) {
 			}
 		})
 	}
+	// 继续未完成的衍生版本批量生成任务
+	if err = service.VariantJobService.Init(); err != nil {
+		return err
+	}
 	// 启动后台自动备份服务
 	go utils.RunTickerTask(context.Background(), time.Hour, initialized, service.BackgroundBackupTask)
 	// 启动后台自动保存点击数据服务
 	go utils.RunTickerTask(context.Background(), 5*time.Minute, initialized, service.ImageCounterService.Save)
+	// 启动后台每日统计汇总服务
+	go utils.RunTickerTask(context.Background(), 5*time.Minute, false, service.DailyStatService.Save)
+	// 定期批量写回缓存文件的访问时间
+	go utils.RunTickerTask(context.Background(), time.Minute, false, service.CacheService.FlushTouched)
+	// 定期清理空闲的 IP 限流器
+	go utils.RunTickerTask(context.Background(), time.Minute, false, service.DeliveryLimitService.Cleanup)
+	// 启动后台访问来源统计汇总服务
+	go utils.RunTickerTask(context.Background(), 5*time.Minute, false, service.AnalyticsService.Save)
+	// 补全早期上传且已在本地缓存的图片尺寸
+	go service.ImageService.BackfillSize(context.Background())
+	// 定期统计磁盘缓存大小，供监控指标读取
+	go utils.RunTickerTask(context.Background(), 5*time.Minute, true, service.CacheService.RefreshDiskStats)
+	// 定期清理过期的断点续传上传
+	go utils.RunTickerTask(context.Background(), time.Hour, true, service.TusService.Cleanup)
 
 	return server.Run(vars.ListenAddr)
 }
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const (
+	ARCHIVE_TYPE_ZIP   = "zip"
+	ARCHIVE_TYPE_TAR   = "tar"
+	ARCHIVE_TYPE_TARGZ = "tar.gz"
+)
+
+// ArchiveType 按文件头识别压缩包类型，不是压缩包时返回空字符串
+func ArchiveType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ARCHIVE_TYPE_ZIP
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return ARCHIVE_TYPE_TARGZ
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return ARCHIVE_TYPE_TAR
+	}
+	return ""
+}
+
+// WalkArchive 依次读取压缩包中的文件，跳过目录、隐藏文件和 macOS 资源文件
+// name 为包内使用 / 分隔的路径，fn 返回错误时停止遍历并返回该错误
+// 无法打开的 ZIP 条目同样交给 fn，读取时返回打开失败的原因，由调用方决定是否继续
+func WalkArchive(r io.ReaderAt, size int64, kind string, fn func(name string, r io.Reader) error) error {
+	if kind == ARCHIVE_TYPE_ZIP {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			name := f.Name
+			// 未标记 UTF-8 的文件名多为 Windows 中文系统生成的 GBK 编码
+			if !utf8.ValidString(name) {
+				if decoded, err := simplifiedchinese.GB18030.NewDecoder().String(name); err == nil {
+					name = decoded
+				}
+			}
+			if skipArchiveEntry(name) {
+				continue
+			}
+			if err = walkZipFile(f, name, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var sr io.Reader = io.NewSectionReader(r, 0, size)
+	if kind == ARCHIVE_TYPE_TARGZ {
+		gr, err := gzip.NewReader(sr)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		sr = gr
+	}
+	tr := tar.NewReader(sr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || skipArchiveEntry(header.Name) {
+			continue
+		}
+		if err = fn(normalizeArchivePath(header.Name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZipFile(f *zip.File, name string, fn func(name string, r io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fn(normalizeArchivePath(name), errReader{err})
+	}
+	defer rc.Close()
+	return fn(normalizeArchivePath(name), rc)
+}
+
+func skipArchiveEntry(name string) bool {
+	name = normalizeArchivePath(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
+
+func normalizeArchivePath(name string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "./")
+}
+
+// errReader 读取时总是返回固定错误
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
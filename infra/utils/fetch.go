@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrFetchForbiddenAddress = errors.New("remote address is not allowed")
+	ErrFetchTooLarge         = errors.New("remote file size exceeds limit")
+	ErrFetchUnsupportedURL   = errors.New("only http and https urls are supported")
+)
+
+// 公网不可达或有特殊用途、但 net.IP 方法没有覆盖的地址段
+var reservedNets = mustParseCIDRs(
+	"0.0.0.0/8",      // 本网络
+	"100.64.0.0/10",  // 运营商级 NAT
+	"192.0.0.0/24",   // IETF 协议分配
+	"198.18.0.0/15",  // 基准测试
+	"240.0.0.0/4",    // 保留
+	"64:ff9b::/96",   // NAT64，可映射到任意 IPv4 地址
+	"64:ff9b:1::/48", // 本地 NAT64
+	"2002::/16",      // 6to4，可映射到任意 IPv4 地址
+	"2001:db8::/32",  // 文档示例
+)
+
+// RemoteFetcher 下载远程文件，连接前检查解析后的地址，重定向时同样会检查
+type RemoteFetcher struct {
+	MaxSize      int64         // 允许的最大文件大小
+	Timeout      time.Duration // 整个请求(含重定向和读取内容)的超时时间
+	MaxRedirects int
+	AllowPrivate bool // 允许访问内网、回环和链路本地地址，仅用于可信的内网部署
+}
+
+// RemoteFile 下载得到的远程文件
+type RemoteFile struct {
+	URL         string // 重定向后的最终地址
+	Filename    string
+	ContentType string // 响应头中的内容类型，未经校验
+	Data        []byte
+}
+
+// Fetch 下载远程文件，超出大小限制时返回 ErrFetchTooLarge
+func (f *RemoteFetcher) Fetch(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkFetchURL(u); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// Control 在 DNS 解析之后、建立连接之前调用，拿到的是实际要连接的地址，可以防止 DNS 重绑定
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !f.AllowPrivate && IsForbiddenIP(net.ParseIP(host)) {
+				return ErrFetchForbiddenAddress
+			}
+			return nil
+		},
+	}
+	client := &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			// 不使用环境变量中的代理，否则检查的是代理地址而不是目标地址
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 15 * time.Second,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > f.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", f.MaxRedirects)
+			}
+			return checkFetchURL(req.URL)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Momoka")
+	req.Header.Set("Accept", "image/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrFetchForbiddenAddress) {
+			return nil, ErrFetchForbiddenAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status error: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.MaxSize {
+		return nil, ErrFetchTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.MaxSize {
+		return nil, ErrFetchTooLarge
+	}
+
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	return &RemoteFile{
+		URL:         resp.Request.URL.String(),
+		Filename:    filename,
+		ContentType: resp.Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+// IsForbiddenIP 判断地址是否为内网、回环、链路本地等不允许服务端访问的地址
+func IsForbiddenIP(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrFetchUnsupportedURL
+	}
+	if u.Hostname() == "" {
+		return ErrFetchUnsupportedURL
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
@@ -151,3 +151,21 @@ func GetFileSize(path string) (int64, error) {
 	}
 	return info.Size(), nil
 }
+
+// SaveFile 将数据写入文件，自动创建上级目录
+func SaveFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadMultipartFile 读取上传文件的全部内容
+func ReadMultipartFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
@@ -6,19 +6,19 @@ import (
 	"github.com/coocood/freecache"
 )
 
-type freeCacheStorage struct {
+type FreeCacheStorage struct {
 	cache *freecache.Cache
 }
 
 // NewFreeCacheStorage 创建一个新的存储实例
 // capacity 单位是字节，例如 100 * 1024 * 1024 表示 100MB
-func NewFreeCacheStorage(capacity int) *freeCacheStorage {
-	return &freeCacheStorage{
+func NewFreeCacheStorage(capacity int) *FreeCacheStorage {
+	return &FreeCacheStorage{
 		cache: freecache.NewCache(capacity),
 	}
 }
 
-func (f *freeCacheStorage) Get(key string) string {
+func (f *FreeCacheStorage) Get(key string) string {
 	value, err := f.cache.Get([]byte(key))
 	if err != nil {
 		return ""
@@ -26,7 +26,7 @@ func (f *freeCacheStorage) Get(key string) string {
 	return string(value)
 }
 
-func (f *freeCacheStorage) Set(key, data string, expire time.Time) {
+func (f *FreeCacheStorage) Set(key, data string, expire time.Time) {
 	var ttl int
 	if expire.IsZero() {
 		ttl = 0 // 不过期
@@ -39,6 +39,10 @@ func (f *freeCacheStorage) Set(key, data string, expire time.Time) {
 	f.cache.Set([]byte(key), []byte(data), ttl)
 }
 
-func (f *freeCacheStorage) Del(key string) {
+func (f *FreeCacheStorage) Del(key string) {
 	f.cache.Del([]byte(key))
 }
+
+func (f *FreeCacheStorage) Clear() {
+	f.cache.Clear()
+}
@@ -1,16 +1,21 @@
 package utils
 
 import (
+	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/h2non/bimg"
 	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
 )
 
 type imageConverter struct {
 	convertChan chan imageConvertTask
+	onConverted func(inputFile, outFile string)
 }
 
 type imageConvertTask struct {
@@ -18,9 +23,11 @@ type imageConvertTask struct {
 	outFile   string
 }
 
-func NewImageConverter() *imageConverter {
+// NewImageConverter 创建后台图片转换器，onConverted 在每次转换成功后调用
+func NewImageConverter(onConverted func(inputFile, outFile string)) *imageConverter {
 	instance := &imageConverter{
 		convertChan: make(chan imageConvertTask, 100),
+		onConverted: onConverted,
 	}
 	go instance.run()
 	return instance
@@ -44,6 +51,22 @@ func (ic *imageConverter) Convert(inputFile, outFile string) {
 	}
 }
 
+// QueueLen 等待后台转换的任务数
+func (ic *imageConverter) QueueLen() int {
+	return len(ic.convertChan)
+}
+
+// ConvertSync 在当前协程中立即转换，供批量任务使用
+func (ic *imageConverter) ConvertSync(inputFile, outFile string) error {
+	if err := ic.convert(inputFile, outFile); err != nil {
+		return err
+	}
+	if ic.onConverted != nil {
+		ic.onConverted(inputFile, outFile)
+	}
+	return nil
+}
+
 func (ic *imageConverter) run() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -64,6 +87,9 @@ func (ic *imageConverter) run() {
 			logrus.Errorf("convert %s image %s to %s failed: %v", imgHash, filepath.Ext(task.inputFile), filepath.Ext(task.outFile), err)
 		} else {
 			logrus.Infof("convert %s image %s to %s success, cost %v", imgHash, filepath.Ext(task.inputFile), filepath.Ext(task.outFile), elapsed)
+			if ic.onConverted != nil {
+				ic.onConverted(task.inputFile, task.outFile)
+			}
 		}
 	}
 }
@@ -89,9 +115,198 @@ func (ic *imageConverter) convert(inputFile, outFile string) error {
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	newImage, err := bimg.NewImage(buffer).Process(convertOpts)
 	if err != nil {
 		return err
 	}
+	metrics.ConvertDuration.WithLabelValues(strings.TrimPrefix(filepath.Ext(outFile), ".")).Observe(time.Since(start).Seconds())
 	return bimg.Write(outFile, newImage)
 }
+
+// ImageSize 读取图片文件的宽高
+func ImageSize(inputFile string) (int, int, error) {
+	buffer, err := bimg.Read(inputFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := bimg.NewImage(buffer).Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.Width, size.Height, nil
+}
+
+// MakeThumbnail 生成指定宽度以内的 WebP 缩略图，不会放大
+func MakeThumbnail(inputFile, outFile string, width int) error {
+	buffer, err := bimg.Read(inputFile)
+	if err != nil {
+		return err
+	}
+	img := bimg.NewImage(buffer)
+	size, err := img.Size()
+	if err != nil {
+		return err
+	}
+	w, h := FitSize(size.Width, size.Height, width, 0)
+	thumb, err := img.Process(bimg.Options{
+		Width:         w,
+		Height:        h,
+		Force:         true,
+		Type:          bimg.WEBP,
+		Quality:       80,
+		StripMetadata: true,
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
+		return err
+	}
+	// 先写入临时文件再改名，避免读到写了一半的缩略图
+	tmpFile := outFile + ".tmp"
+	if err = bimg.Write(tmpFile, thumb); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, outFile)
+}
+
+// ProcessUpload 按上传策略处理图片，返回处理后的数据、内容类型、扩展名和尺寸
+// 策略未生效或处理结果不优于原图时原样返回，此时 Changed 为 false
+func ProcessUpload(data []byte, contentType, extName string, policy common.UploadPolicy) (result UploadResult, err error) {
+	result = UploadResult{Data: data, ContentType: contentType, ExtName: extName}
+
+	img := bimg.NewImage(data)
+	srcType := bimg.DetermineImageType(data)
+	// 动图和矢量图不做处理，避免丢帧或栅格化
+	if srcType == bimg.UNKNOWN || srcType == bimg.GIF || srcType == bimg.SVG {
+		return result, nil
+	}
+	size, err := img.Size()
+	if err != nil {
+		return result, err
+	}
+	result.Width, result.Height = size.Width, size.Height
+
+	opts := bimg.Options{StripMetadata: true}
+	// 缩小超出尺寸限制的图片
+	width, height := FitSize(size.Width, size.Height, policy.MaxWidth, policy.MaxHeight)
+	resized := width != size.Width || height != size.Height
+	if resized {
+		opts.Width, opts.Height, opts.Force = width, height, true
+	}
+	// 转换到目标格式
+	targetType := srcType
+	if t, ok := uploadFormats[policy.ConvertFormat]; ok && t != srcType {
+		targetType = t
+		opts.Type = t
+		opts.Quality = 90
+		opts.Speed = 7
+	}
+	// JPEG 经 libvips 解码再编码必然有损，只调整编码方式，不经过 libvips
+	if policy.Optimize && !resized && srcType == bimg.JPEG && targetType == bimg.JPEG {
+		out, err := OptimizeJPEG(data)
+		if err != nil || len(out) >= len(data) {
+			return result, err
+		}
+		result.Data = out
+		result.Changed = true
+		return result, nil
+	}
+	// PNG 按最高压缩级别无损重新编码
+	optimize := policy.Optimize && targetType == bimg.PNG
+	if optimize {
+		opts.Type = targetType
+		opts.Compression = 9
+	}
+	if !resized && targetType == srcType && !optimize {
+		return result, nil
+	}
+
+	out, err := img.Process(opts)
+	if err != nil {
+		return result, err
+	}
+	// 仅做重压缩时，体积没有变小就保持原图
+	if !resized && targetType == srcType && len(out) >= len(data) {
+		return result, nil
+	}
+
+	result.Data = out
+	result.Width, result.Height = width, height
+	if targetType != srcType {
+		typeName := bimg.ImageTypeName(targetType)
+		result.ContentType = "image/" + typeName
+		result.ExtName = "." + strings.Replace(typeName, "jpeg", "jpg", 1)
+	}
+	result.Changed = true
+	return result, nil
+}
+
+// UploadResult 上传策略处理结果
+type UploadResult struct {
+	Data        []byte
+	ContentType string
+	ExtName     string
+	Width       int
+	Height      int
+	Changed     bool
+}
+
+var uploadFormats = map[string]bimg.ImageType{
+	"webp": bimg.WEBP,
+	"avif": bimg.AVIF,
+	"jpeg": bimg.JPEG,
+	"jpg":  bimg.JPEG,
+	"png":  bimg.PNG,
+}
+
+// imageExtNames 常见图片内容类型对应的扩展名
+var imageExtNames = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/avif":    ".avif",
+	"image/heif":    ".heic",
+	"image/bmp":     ".bmp",
+	"image/tiff":    ".tiff",
+	"image/svg+xml": ".svg",
+	"image/x-icon":  ".ico",
+}
+
+// DetectImageType 按文件内容识别图片类型，不是可识别的图片时返回空字符串
+func DetectImageType(data []byte) string {
+	if contentType := http.DetectContentType(data); strings.HasPrefix(contentType, "image/") {
+		return contentType
+	}
+	// http.DetectContentType 不识别 AVIF/HEIF/SVG 等格式，交给 libvips 判断
+	switch t := bimg.DetermineImageType(data); t {
+	case bimg.UNKNOWN:
+		return ""
+	case bimg.SVG:
+		return "image/svg+xml"
+	default:
+		return "image/" + bimg.ImageTypeName(t)
+	}
+}
+
+// ImageExtName 图片内容类型对应的扩展名，未知类型返回空字符串
+func ImageExtName(contentType string) string {
+	return imageExtNames[contentType]
+}
+
+// FitSize 计算等比缩放到 maxWidth x maxHeight 以内的尺寸，不会放大，max 为 0 表示不限制
+func FitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	ratio := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		ratio = min(ratio, float64(maxWidth)/float64(width))
+	}
+	if maxHeight > 0 && height > maxHeight {
+		ratio = min(ratio, float64(maxHeight)/float64(height))
+	}
+	if ratio == 1.0 {
+		return width, height
+	}
+	return max(1, int(float64(width)*ratio)), max(1, int(float64(height)*ratio))
+}
@@ -0,0 +1,696 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"sort"
+)
+
+var errJPEGUnsupported = errors.New("unsupported jpeg structure")
+
+// OptimizeJPEG 无损优化 JPEG：去掉注释和无关元数据，并为熵编码重建最优 Huffman 表
+// 只改变编码方式，不重新量化，解码得到的像素与原图完全一致；渐进式等其它编码只去掉元数据
+// 结果会和原图逐像素比较，不一致时返回错误
+func OptimizeJPEG(data []byte) ([]byte, error) {
+	p, err := parseJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.rebuild()
+	if err != nil {
+		return nil, err
+	}
+	if err = sameJPEGPixels(data, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte // 不含标记和长度
+	ecs    []byte // SOS 之后的熵编码数据，包含 RST 标记
+}
+
+type jpegComponent struct {
+	id     byte
+	h, v   int
+	blocks int // 非交织扫描时的块数
+}
+
+type jpegHuffman struct {
+	maxCode [17]int32
+	valPtr  [17]int32
+	minCode [17]int32
+	values  []byte
+}
+
+type jpegParser struct {
+	segments   []jpegSegment
+	components []jpegComponent
+	width      int
+	height     int
+	huffman    bool // 基线或扩展顺序式 Huffman 编码，可以重建 Huffman 表
+}
+
+func parseJPEG(data []byte) (*jpegParser, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errJPEGUnsupported
+	}
+	p := &jpegParser{}
+	pos := 2
+	for {
+		// 标记前可以有任意个 0xff 填充
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+2 > len(data) || data[pos] != 0xff {
+			return nil, errJPEGUnsupported
+		}
+		marker := data[pos+1]
+		pos += 2
+		if marker == 0xd9 {
+			return p, nil
+		}
+		if marker >= 0xd0 && marker <= 0xd7 || marker == 0x01 {
+			continue
+		}
+		if pos+2 > len(data) {
+			return nil, errJPEGUnsupported
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, errJPEGUnsupported
+		}
+		seg := jpegSegment{marker: marker, data: data[pos+2 : pos+length]}
+		pos += length
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			if err := p.parseSOF(seg.data); err != nil {
+				return nil, err
+			}
+			p.huffman = true
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			if err := p.parseSOF(seg.data); err != nil {
+				return nil, err
+			}
+		case marker == 0xdc:
+			// DNL 定义的高度在扫描之后才出现，不做处理
+			return nil, errJPEGUnsupported
+		case marker == 0xda:
+			// 熵编码数据到下一个非 RST 标记为止
+			end := pos
+			for end+1 < len(data) {
+				if data[end] == 0xff && data[end+1] != 0 && (data[end+1] < 0xd0 || data[end+1] > 0xd7) {
+					break
+				}
+				end++
+			}
+			if end+1 >= len(data) {
+				return nil, errJPEGUnsupported
+			}
+			seg.ecs = data[pos:end]
+			pos = end
+		}
+		p.segments = append(p.segments, seg)
+	}
+}
+
+func (p *jpegParser) parseSOF(b []byte) error {
+	if p.components != nil || len(b) < 6 {
+		return errJPEGUnsupported
+	}
+	p.height = int(binary.BigEndian.Uint16(b[1:]))
+	p.width = int(binary.BigEndian.Uint16(b[3:]))
+	n := int(b[5])
+	if p.width == 0 || p.height == 0 || n == 0 || len(b) < 6+3*n {
+		return errJPEGUnsupported
+	}
+	hmax, vmax := 1, 1
+	for i := range n {
+		c := jpegComponent{id: b[6+3*i], h: int(b[7+3*i] >> 4), v: int(b[7+3*i] & 0x0f)}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+			return errJPEGUnsupported
+		}
+		hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+		p.components = append(p.components, c)
+	}
+	for i := range p.components {
+		c := &p.components[i]
+		w := (p.width*c.h + hmax - 1) / hmax
+		h := (p.height*c.v + vmax - 1) / vmax
+		c.blocks = ((w + 7) / 8) * ((h + 7) / 8)
+	}
+	return nil
+}
+
+// keepSegment 保留解码和显示需要的段，去掉 EXIF、XMP、IPTC 和注释
+func keepSegment(seg jpegSegment) bool {
+	switch {
+	case seg.marker == 0xfe:
+		return false
+	case seg.marker == 0xe0:
+		return bytes.HasPrefix(seg.data, []byte("JFIF\x00"))
+	case seg.marker == 0xe1:
+		// 无法无损旋转，需要旋转的图片保留 EXIF 中的方向信息
+		return bytes.HasPrefix(seg.data, []byte("Exif\x00\x00")) && exifOrientation(seg.data[6:]) > 1
+	case seg.marker == 0xe2:
+		return bytes.HasPrefix(seg.data, []byte("ICC_PROFILE\x00"))
+	case seg.marker == 0xee:
+		// Adobe 段决定颜色空间的转换方式
+		return bytes.HasPrefix(seg.data, []byte("Adobe"))
+	case seg.marker >= 0xe0 && seg.marker <= 0xef:
+		return false
+	}
+	return true
+}
+
+// exifOrientation 读取 TIFF 结构中 IFD0 的方向标签，没有时返回 0
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// rebuild 生成优化后的 JPEG，第一遍统计符号频率，第二遍用最优 Huffman 表重新编码
+func (p *jpegParser) rebuild() ([]byte, error) {
+	var freqs [2][4]*[257]int64
+	if p.huffman {
+		err := p.forEachScan(func(seg jpegSegment, tables [2][4]*jpegHuffman, restart int) error {
+			return p.decodeScan(seg, tables, restart, func(class, id int, symbol byte, _ uint32, _ int) {
+				if freqs[class][id] == nil {
+					freqs[class][id] = new([257]int64)
+				}
+				freqs[class][id][symbol]++
+			}, func() {})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 同一编号的表在所有扫描中共用一张新表
+	var dht []byte
+	var codes [2][4]*huffmanCodes
+	for class := range 2 {
+		for id := range 4 {
+			if freqs[class][id] == nil {
+				continue
+			}
+			bits, values := optimalHuffman(freqs[class][id])
+			dht = append(dht, byte(class<<4|id))
+			dht = append(dht, bits[1:]...)
+			dht = append(dht, values...)
+			codes[class][id] = newHuffmanCodes(bits, values)
+		}
+	}
+
+	out := []byte{0xff, 0xd8}
+	writeSegment := func(marker byte, data []byte) {
+		out = append(out, 0xff, marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(data)+2))
+		out = append(out, data...)
+	}
+	if !p.huffman {
+		for _, seg := range p.segments {
+			if keepSegment(seg) {
+				writeSegment(seg.marker, seg.data)
+				out = append(out, seg.ecs...)
+			}
+		}
+		return append(out, 0xff, 0xd9), nil
+	}
+	// 原有的 Huffman 表只用于解码，新表写在第一个扫描之前，其它段保持原有顺序
+	var tables [2][4]*jpegHuffman
+	var restart int
+	var dhtWritten bool
+	for _, seg := range p.segments {
+		switch {
+		case seg.marker == 0xc4:
+			if err := parseDHT(seg.data, &tables); err != nil {
+				return nil, err
+			}
+			continue
+		case seg.marker == 0xdd:
+			restart = int(binary.BigEndian.Uint16(seg.data))
+		case !keepSegment(seg):
+			continue
+		}
+		if seg.marker == 0xda && !dhtWritten {
+			writeSegment(0xc4, dht)
+			dhtWritten = true
+		}
+		writeSegment(seg.marker, seg.data)
+		if seg.marker != 0xda {
+			continue
+		}
+		w := &jpegBitWriter{out: out}
+		var rst byte
+		err := p.decodeScan(seg, tables, restart, func(class, id int, symbol byte, extra uint32, extraLen int) {
+			c := codes[class][id]
+			w.write(uint32(c.code[symbol]), int(c.size[symbol]))
+			w.write(extra, extraLen)
+		}, func() {
+			w.flush()
+			w.out = append(w.out, 0xff, 0xd0+rst)
+			rst = (rst + 1) & 7
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.flush()
+		out = w.out
+	}
+	return append(out, 0xff, 0xd9), nil
+}
+
+// forEachScan 依次处理每个扫描，同时传入扫描时生效的 Huffman 表和重启间隔
+func (p *jpegParser) forEachScan(fn func(seg jpegSegment, tables [2][4]*jpegHuffman, restart int) error) error {
+	var tables [2][4]*jpegHuffman
+	var restart int
+	for _, seg := range p.segments {
+		switch seg.marker {
+		case 0xc4:
+			if err := parseDHT(seg.data, &tables); err != nil {
+				return err
+			}
+		case 0xdd:
+			if len(seg.data) < 2 {
+				return errJPEGUnsupported
+			}
+			restart = int(binary.BigEndian.Uint16(seg.data))
+		case 0xda:
+			if err := fn(seg, tables, restart); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeScan 解码顺序式扫描，emit 接收每个 Huffman 符号及其后的附加位，onRestart 在每个 RST 标记处调用
+func (p *jpegParser) decodeScan(seg jpegSegment, tables [2][4]*jpegHuffman, restart int,
+	emit func(class, id int, symbol byte, extra uint32, extraLen int), onRestart func()) error {
+	b := seg.data
+	if len(b) < 1 {
+		return errJPEGUnsupported
+	}
+	n := int(b[0])
+	if n < 1 || n > 4 || len(b) < 1+2*n+3 {
+		return errJPEGUnsupported
+	}
+	// 顺序式扫描的频谱选择必须是 0-63，逐次逼近为 0
+	if b[1+2*n] != 0 || b[2+2*n] != 63 || b[3+2*n] != 0 {
+		return errJPEGUnsupported
+	}
+	type scanComp struct {
+		comp   *jpegComponent
+		dc, ac *jpegHuffman
+		dcID   int
+		acID   int
+	}
+	comps := make([]scanComp, n)
+	for i := range n {
+		id, sel := b[1+2*i], b[2+2*i]
+		var comp *jpegComponent
+		for j := range p.components {
+			if p.components[j].id == id {
+				comp = &p.components[j]
+			}
+		}
+		dcID, acID := int(sel>>4), int(sel&0x0f)
+		if comp == nil || dcID > 3 || acID > 3 || tables[0][dcID] == nil || tables[1][acID] == nil {
+			return errJPEGUnsupported
+		}
+		comps[i] = scanComp{comp: comp, dc: tables[0][dcID], ac: tables[1][acID], dcID: dcID, acID: acID}
+	}
+
+	// 每个 MCU 内按分量顺序排列的块
+	var mcuBlocks []int
+	var mcus int
+	if n == 1 {
+		mcuBlocks = []int{0}
+		mcus = comps[0].comp.blocks
+	} else {
+		hmax, vmax := 1, 1
+		for _, c := range p.components {
+			hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+		}
+		for i, c := range comps {
+			for range c.comp.h * c.comp.v {
+				mcuBlocks = append(mcuBlocks, i)
+			}
+		}
+		mcus = ((p.width + 8*hmax - 1) / (8 * hmax)) * ((p.height + 8*vmax - 1) / (8 * vmax))
+	}
+
+	r := &jpegBitReader{data: seg.ecs}
+	for m := range mcus {
+		if restart > 0 && m > 0 && m%restart == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			onRestart()
+		}
+		for _, ci := range mcuBlocks {
+			c := comps[ci]
+			// DC 系数：符号为差值的位数
+			s, err := r.decode(c.dc)
+			if err != nil {
+				return err
+			}
+			if s > 16 {
+				return errJPEGUnsupported
+			}
+			extra, err := r.bits(int(s))
+			if err != nil {
+				return err
+			}
+			emit(0, c.dcID, s, extra, int(s))
+			// AC 系数：高 4 位为零游程，低 4 位为位数
+			for k := 1; k < 64; {
+				rs, err := r.decode(c.ac)
+				if err != nil {
+					return err
+				}
+				size := int(rs & 0x0f)
+				extra, err := r.bits(size)
+				if err != nil {
+					return err
+				}
+				emit(1, c.acID, rs, extra, size)
+				if size == 0 {
+					if rs>>4 != 15 {
+						break
+					}
+					k += 16
+					continue
+				}
+				k += int(rs>>4) + 1
+			}
+		}
+	}
+	return nil
+}
+
+func parseDHT(b []byte, tables *[2][4]*jpegHuffman) error {
+	for len(b) > 0 {
+		if len(b) < 17 {
+			return errJPEGUnsupported
+		}
+		class, id := int(b[0]>>4), int(b[0]&0x0f)
+		if class > 1 || id > 3 {
+			return errJPEGUnsupported
+		}
+		total := 0
+		for i := 1; i <= 16; i++ {
+			total += int(b[i])
+		}
+		if len(b) < 17+total {
+			return errJPEGUnsupported
+		}
+		h := &jpegHuffman{values: b[17 : 17+total]}
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			count := int32(b[l])
+			h.valPtr[l] = k
+			h.minCode[l] = code
+			code += count
+			k += count
+			h.maxCode[l] = code - 1
+			if count == 0 {
+				h.maxCode[l] = -1
+			}
+			code <<= 1
+		}
+		tables[class][id] = h
+		b = b[17+total:]
+	}
+	return nil
+}
+
+type jpegBitReader struct {
+	data  []byte
+	pos   int
+	acc   uint32
+	nbits int
+}
+
+// fill 读入一个字节，0xff 后的 0x00 为填充字节，遇到标记时补 1
+func (r *jpegBitReader) fill() error {
+	if r.pos >= len(r.data) {
+		return errJPEGUnsupported
+	}
+	c := r.data[r.pos]
+	if c == 0xff {
+		if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0x00 {
+			r.pos += 2
+		} else {
+			// 标记前的剩余位按 1 填充
+			c = 0xff
+		}
+	} else {
+		r.pos++
+	}
+	r.acc = r.acc<<8 | uint32(c)
+	r.nbits += 8
+	return nil
+}
+
+func (r *jpegBitReader) bits(n int) (uint32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	for r.nbits < n {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	r.nbits -= n
+	return (r.acc >> r.nbits) & (1<<n - 1), nil
+}
+
+func (r *jpegBitReader) decode(h *jpegHuffman) (byte, error) {
+	var code int32
+	for l := 1; l <= 16; l++ {
+		bit, err := r.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int32(bit)
+		if code <= h.maxCode[l] {
+			idx := h.valPtr[l] + code - h.minCode[l]
+			if int(idx) >= len(h.values) {
+				return 0, errJPEGUnsupported
+			}
+			return h.values[idx], nil
+		}
+	}
+	return 0, errJPEGUnsupported
+}
+
+// restart 丢弃剩余的填充位并跳过 RST 标记
+func (r *jpegBitReader) restart() error {
+	r.acc, r.nbits = 0, 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xff || r.data[r.pos+1] < 0xd0 || r.data[r.pos+1] > 0xd7 {
+		return errJPEGUnsupported
+	}
+	r.pos += 2
+	return nil
+}
+
+type jpegBitWriter struct {
+	out   []byte
+	acc   uint32
+	nbits int
+}
+
+func (w *jpegBitWriter) write(v uint32, n int) {
+	for n > 0 {
+		take := min(n, 8)
+		n -= take
+		w.acc = w.acc<<take | (v>>n)&(1<<take-1)
+		w.nbits += take
+		for w.nbits >= 8 {
+			w.nbits -= 8
+			c := byte(w.acc >> w.nbits)
+			w.out = append(w.out, c)
+			if c == 0xff {
+				w.out = append(w.out, 0x00)
+			}
+		}
+	}
+}
+
+// flush 剩余的位用 1 补齐到整字节
+func (w *jpegBitWriter) flush() {
+	if w.nbits > 0 {
+		w.write(1<<(8-w.nbits)-1, 8-w.nbits)
+	}
+	w.acc, w.nbits = 0, 0
+}
+
+type huffmanCodes struct {
+	code [256]uint16
+	size [256]uint8
+}
+
+func newHuffmanCodes(bits [17]byte, values []byte) *huffmanCodes {
+	c := &huffmanCodes{}
+	code, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for range int(bits[l]) {
+			c.code[values[k]] = code
+			c.size[values[k]] = uint8(l)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return c
+}
+
+// optimalHuffman 按符号频率生成码长不超过 16 位的 Huffman 表，算法见 JPEG 标准附录 K.2
+// freq[256] 为保留的伪符号，保证不会出现全 1 的码字
+func optimalHuffman(counts *[257]int64) (bits [17]byte, values []byte) {
+	freq := *counts
+	freq[256] = 1
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// 找出频率最小的两个符号，频率相同时取编号较大的
+		c1, c2 := -1, -1
+		var v int64 = 1 << 62
+		for i := range 257 {
+			if freq[i] != 0 && freq[i] <= v {
+				v, c1 = freq[i], i
+			}
+		}
+		v = 1 << 62
+		for i := range 257 {
+			if freq[i] != 0 && freq[i] <= v && i != c1 {
+				v, c2 = freq[i], i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codeSize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codeSize[c1]++
+		}
+		others[c1] = c2
+		codeSize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codeSize[c2]++
+		}
+	}
+	var lengths [33]int
+	for i := range 257 {
+		if codeSize[i] > 0 {
+			lengths[codeSize[i]]++
+		}
+	}
+	// 把超过 16 位的码长调整到 16 位以内
+	for i := 32; i > 16; i-- {
+		for lengths[i] > 0 {
+			j := i - 2
+			for lengths[j] == 0 {
+				j--
+			}
+			lengths[i] -= 2
+			lengths[i-1]++
+			lengths[j+1] += 2
+			lengths[j]--
+		}
+	}
+	// 去掉保留符号占用的最长码字
+	i := 16
+	for lengths[i] == 0 {
+		i--
+	}
+	lengths[i]--
+	for l := 1; l <= 16; l++ {
+		bits[l] = byte(lengths[l])
+	}
+	type symbol struct {
+		value byte
+		size  int
+	}
+	var symbols []symbol
+	for s := range 256 {
+		if codeSize[s] > 0 {
+			symbols = append(symbols, symbol{byte(s), codeSize[s]})
+		}
+	}
+	sort.SliceStable(symbols, func(a, b int) bool { return symbols[a].size < symbols[b].size })
+	for _, s := range symbols {
+		values = append(values, s.value)
+	}
+	return bits, values
+}
+
+// sameJPEGPixels 解码两张 JPEG 并逐像素比较
+func sameJPEGPixels(a, b []byte) error {
+	imgA, err := jpeg.Decode(bytes.NewReader(a))
+	if err != nil {
+		return err
+	}
+	imgB, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if !samePixels(imgA, imgB) {
+		return errors.New("optimized jpeg differs from original")
+	}
+	return nil
+}
+
+func samePixels(a, b image.Image) bool {
+	switch a := a.(type) {
+	case *image.YCbCr:
+		b, ok := b.(*image.YCbCr)
+		return ok && a.Rect == b.Rect && a.SubsampleRatio == b.SubsampleRatio &&
+			bytes.Equal(a.Y, b.Y) && bytes.Equal(a.Cb, b.Cb) && bytes.Equal(a.Cr, b.Cr)
+	case *image.Gray:
+		b, ok := b.(*image.Gray)
+		return ok && a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+	case *image.CMYK:
+		b, ok := b.(*image.CMYK)
+		return ok && a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+	}
+	return false
+}
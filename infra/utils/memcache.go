@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryCache 按字节预算淘汰的 LRU 内存缓存
+type MemoryCache struct {
+	lock   sync.Mutex
+	budget int64
+	used   int64
+	lru    *list.List
+	items  map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryCache 创建内存缓存，budget 为最多占用的字节数
+func NewMemoryCache(budget int64) *MemoryCache {
+	return &MemoryCache{
+		budget: budget,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*memoryCacheEntry).data, true
+}
+
+func (m *MemoryCache) Has(key string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.items[key]
+	return ok
+}
+
+// Set 写入缓存，超出预算时淘汰最久未使用的项
+func (m *MemoryCache) Set(key string, data []byte) {
+	size := int64(len(data))
+	if size > m.budget {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if elem, ok := m.items[key]; ok {
+		m.removeLocked(elem)
+	}
+	for m.used+size > m.budget {
+		m.removeLocked(m.lru.Back())
+	}
+	m.items[key] = m.lru.PushFront(&memoryCacheEntry{key: key, data: data})
+	m.used += size
+}
+
+func (m *MemoryCache) Del(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if elem, ok := m.items[key]; ok {
+		m.removeLocked(elem)
+	}
+}
+
+// Stats 返回缓存项数和占用字节数
+func (m *MemoryCache) Stats() (int, int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.items), m.used
+}
+
+func (m *MemoryCache) removeLocked(elem *list.Element) {
+	entry := m.lru.Remove(elem).(*memoryCacheEntry)
+	delete(m.items, entry.key)
+	m.used -= int64(len(entry.data))
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶，按固定速率补充令牌，最多积累 burst 个
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket 创建装满令牌的令牌桶
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) refillLocked(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// Allow 尝试取走一个令牌，令牌不足时返回 false
+func (b *TokenBucket) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refillLocked(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Reserve 预支 n 个令牌，返回需要等待的时长
+func (b *TokenBucket) Reserve(n float64) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refillLocked(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Burst 令牌桶容量
+func (b *TokenBucket) Burst() float64 {
+	return b.burst
+}
+
+type throttledReader struct {
+	reader     io.Reader
+	buckets    []*TokenBucket
+	chunk      int
+	onThrottle func()
+	throttled  bool
+}
+
+// ThrottleReader 按字节数从令牌桶中取令牌限制读取速度，首次需要等待时调用 onThrottle
+func ThrottleReader(r io.Reader, onThrottle func(), buckets ...*TokenBucket) io.Reader {
+	chunk := 32 * 1024
+	for _, bucket := range buckets {
+		chunk = min(chunk, max(int(bucket.Burst()), 1))
+	}
+	return &throttledReader{reader: r, buckets: buckets, chunk: chunk, onThrottle: onThrottle}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		var wait time.Duration
+		for _, bucket := range r.buckets {
+			wait = max(wait, bucket.Reserve(float64(n)))
+		}
+		if wait > 0 {
+			if !r.throttled {
+				r.throttled = true
+				if r.onThrottle != nil {
+					r.onThrottle()
+				}
+			}
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package utils
+
+import "strings"
+
+// uaFamilies 按顺序匹配的客户端特征，靠前的优先，例如 Edge 的 UA 中同时包含 Chrome 和 Safari
+var uaFamilies = []struct {
+	keyword string
+	family  string
+}{
+	{"googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"baiduspider", "Baiduspider"},
+	{"yandexbot", "YandexBot"},
+	{"duckduckbot", "DuckDuckBot"},
+	{"sogou", "Sogou Spider"},
+	{"bytespider", "Bytespider"},
+	{"applebot", "Applebot"},
+	{"facebookexternalhit", "Facebook Bot"},
+	{"twitterbot", "Twitterbot"},
+	{"discordbot", "Discordbot"},
+	{"telegrambot", "TelegramBot"},
+	{"slackbot", "Slackbot"},
+	{"bot", "Other Bot"},
+	{"spider", "Other Bot"},
+	{"crawler", "Other Bot"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python", "Python"},
+	{"go-http-client", "Go HTTP Client"},
+	{"okhttp", "OkHttp"},
+	{"micromessenger", "WeChat"},
+	{"qq/", "QQ"},
+	{"edg/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"chromium", "Chrome"},
+	{"safari/", "Safari"},
+}
+
+// UAFamily 将 User-Agent 归类为浏览器或爬虫家族
+func UAFamily(userAgent string) string {
+	if userAgent == "" {
+		return "(empty)"
+	}
+	ua := strings.ToLower(userAgent)
+	for _, item := range uaFamilies {
+		if strings.Contains(ua, item.keyword) {
+			return item.family
+		}
+	}
+	return "Other"
+}
@@ -4,30 +4,48 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/oschwald/maxminddb-golang"
 	"github.com/speps/go-hashids"
 	"github.com/zjyl1994/cap-go"
+	"github.com/zjyl1994/momoka/infra/common"
 	"gorm.io/gorm"
 )
 
 var (
-	DebugMode      bool
-	ListenAddr     string
-	DataPath       string
-	Secret         string
-	Database       *gorm.DB
-	S3Config       S3Conf
-	S3Client       *s3.Client
-	S3Debug        bool
-	HashID         *hashids.HashID
-	AutoCleanDays  int
-	AutoCleanItems int
-	BootTime       time.Time
-	BaseURL        string
-	SiteName       string
-	CapInstance    cap.ICap
-	SkipAuth       bool
-	ImageConverter ImageConverterIFace
-	AutoConvFormat []string
+	DebugMode        bool
+	ListenAddr       string
+	DataPath         string
+	Secret           string
+	Database         *gorm.DB
+	S3Config         S3Conf
+	S3Client         *s3.Client
+	S3Debug          bool
+	HashID           *hashids.HashID
+	AutoCleanDays    int
+	AutoCleanItems   int
+	BootTime         time.Time
+	BaseURL          string
+	SiteName         string
+	CapInstance      cap.ICap
+	SkipAuth         bool
+	ImageConverter   ImageConverterIFace
+	AutoConvFormat   []string
+	VariantMinSaving int
+	StatRetentionDay int
+	UploadPolicy     common.UploadPolicy
+	HotlinkPolicy    common.HotlinkPolicy
+	DeliveryLimit    common.DeliveryLimitPolicy
+	CachePolicy      common.CachePolicy
+	LinkFormat       common.LinkFormatPolicy
+	Gallery          common.GalleryPolicy
+	GeoIPReader      *maxminddb.Reader
+
+	MetricsToken      string
+	MetricsListenAddr string
+
+	FetchAllowPrivate bool
+	TrustedProxies    []string // 可信反向代理的 IP 或 CIDR，只有来自这些地址的请求才读取代理头中的客户端 IP
+	ProxyHeader       string
 )
 
 type S3Conf struct {
@@ -41,4 +59,6 @@ type S3Conf struct {
 
 type ImageConverterIFace interface {
 	Convert(inputFile, outFile string)
+	ConvertSync(inputFile, outFile string) error
+	QueueLen() int
 }
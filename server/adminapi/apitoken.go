@@ -0,0 +1,157 @@
+package adminapi
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+func APITokenListHandler(c *fiber.Ctx) error {
+	tokens, err := service.APITokenService.List(vars.Database)
+	if err != nil {
+		logrus.Errorln("Failed to list api tokens:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list api tokens",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"tokens": tokens,
+	})
+}
+
+func APITokenCreateHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name     string   `json:"name"`
+		Scopes   []string `json:"scopes"`
+		AllowIPs []string `json:"allow_ips"` // IP 或 CIDR，空为不限制
+		Expire   int64    `json:"expire"`    // 有效期(秒)，0 为永不过期
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	token := &common.APIToken{
+		Name: strings.TrimSpace(req.Name),
+	}
+	for _, scope := range req.Scopes {
+		token.ScopeList = append(token.ScopeList, strings.TrimSpace(scope))
+	}
+	for _, ip := range req.AllowIPs {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			token.AllowIPList = append(token.AllowIPList, ip)
+		}
+	}
+	if req.Expire > 0 {
+		token.ExpireTime = time.Now().Unix() + req.Expire
+	}
+	if err := token.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := service.APITokenService.Add(vars.Database, token); err != nil {
+		logrus.Errorln("Failed to create api token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create api token",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"token": token,
+	})
+}
+
+func APITokenRevokeHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid id format",
+		})
+	}
+	if err := service.APITokenService.Revoke(vars.Database, id); err != nil {
+		logrus.Errorln("Failed to revoke api token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to revoke api token",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func APITokenDeleteHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid id format",
+		})
+	}
+	if err := service.APITokenService.Delete(vars.Database, id); err != nil {
+		logrus.Errorln("Failed to delete api token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete api token",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// APITokenShareXHandler 创建一个只有上传权限的令牌，返回包含该令牌的 ShareX 配置文件(.sxcu)
+func APITokenShareXHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name   string `json:"name"`
+		Expire int64  `json:"expire"` // 有效期(秒)，0 为永不过期
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid request body",
+			})
+		}
+	}
+
+	token := &common.APIToken{
+		Name:      strings.TrimSpace(req.Name),
+		ScopeList: []string{common.API_TOKEN_SCOPE_UPLOAD},
+	}
+	if token.Name == "" {
+		token.Name = "ShareX"
+	}
+	if req.Expire > 0 {
+		token.ExpireTime = time.Now().Unix() + req.Expire
+	}
+	if err := service.APITokenService.Add(vars.Database, token); err != nil {
+		logrus.Errorln("Failed to create api token:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create api token",
+		})
+	}
+
+	// Build upload URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	c.Attachment("momoka.sxcu")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"Version":         "14.0.0",
+		"Name":            vars.SiteName,
+		"DestinationType": "ImageUploader",
+		"RequestMethod":   "POST",
+		"RequestURL":      baseUrl + "/api/upload",
+		"Headers": fiber.Map{
+			"Authorization": "Bearer " + token.Token,
+		},
+		"Body":         "MultipartFormData",
+		"FileFormName": "file",
+		"URL":          "{json:url}",
+		"ErrorMessage": "{json:error}",
+	})
+}
@@ -46,6 +46,10 @@ func DashboardDataHandler(c *fiber.Ctx) error {
 			"bandwidth":         imgCtr.TotalBandwidth,
 			"monthly_click":     imgCtr.MonthlyClick,
 			"monthly_bandwidth": imgCtr.MonthlyBandwidth,
+			"blocked":           imgCtr.TotalBlocked,
+			"monthly_blocked":   imgCtr.MonthlyBlocked,
+			"limited":           imgCtr.TotalLimited,
+			"monthly_limited":   imgCtr.MonthlyLimited,
 		},
 		"stat": fiber.Map{
 			"load": fiber.Map{
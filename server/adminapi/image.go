@@ -1,12 +1,14 @@
 package adminapi
 
 import (
-	"path/filepath"
+	"context"
+	"errors"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 	"github.com/zjyl1994/momoka/infra/common"
 	"github.com/zjyl1994/momoka/infra/utils"
@@ -22,43 +24,86 @@ func ImageUploadHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate file type
-	if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "only image files are allowed",
-		})
-	}
-
-	// Validate file size (10MB limit)
+	// Validate file size before reading it into memory
 	if file.Size > common.MAX_IMAGE_SIZE {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "file size exceeds limit",
 		})
 	}
 
-	// Calculate file hash
-	hash, err := utils.MultipartFileHeaderHash(file)
+	data, err := utils.ReadMultipartFile(file)
 	if err != nil {
-		logrus.Errorln("Failed to calculate file hash:", err)
+		logrus.Errorln("Failed to read uploaded file:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to calculate file hash",
+			"error": "failed to read file",
 		})
 	}
 
-	// Check if image with same hash already exists
-	existingImage, err := service.ImageService.GetByHash(vars.Database, hash)
+	// Parse tags
+	var tags []string
+	tagsStr := c.FormValue("tags")
+	if tagsStr != "" {
+		tags = strings.Split(tagsStr, ",")
+		for i, tag := range tags {
+			tags[i] = strings.TrimSpace(tag)
+		}
+	}
+
+	private, _ := strconv.ParseBool(c.FormValue("private"))
+
+	// Apply upload policy, deduplicate by hash and save
+	image, _, err := service.UploadService.Ingest(&common.UploadFile{
+		Filename:    file.Filename,
+		ContentType: file.Header.Get("Content-Type"),
+		Data:        data,
+		Remark:      c.FormValue("remark"),
+		Tags:        tags,
+		Private:     private,
+	})
 	if err != nil {
-		logrus.Errorln("Failed to check existing image by hash:", err)
+		if errors.Is(err, service.ErrNotImage) || errors.Is(err, service.ErrFileTooLarge) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		logrus.Errorln("Failed to save uploaded image:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to check existing image",
+			"error": "failed to save image",
 		})
 	}
 
-	// Get file extension and filename
-	filename := file.Filename
-	extName := filepath.Ext(filename)
-	name := strings.TrimSuffix(filename, extName)
-	remark := c.FormValue("remark")
+	// Build response URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	if image.URL != "" {
+		image.URL = baseUrl + image.URL
+	}
+	if withLinks, _ := strconv.ParseBool(c.FormValue("links")); withLinks {
+		service.LinkFormatService.Fill(image, baseUrl)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"image": image,
+	})
+}
+
+// ImageBatchUploadHandler 一次上传多个文件，支持 ZIP/TAR 压缩包，返回每个文件的处理结果
+func ImageBatchUploadHandler(c *fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid multipart form",
+		})
+	}
+	files := append(form.File["files"], form.File["file"]...)
+	if len(files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "files parameter is required",
+		})
+	}
 
 	// Parse tags
 	var tags []string
@@ -70,61 +115,65 @@ func ImageUploadHandler(c *fiber.Ctx) error {
 		}
 	}
 
-	var image *common.Image
+	private, _ := strconv.ParseBool(c.FormValue("private"))
+	pathTags, _ := strconv.ParseBool(c.FormValue("path_tags"))
 
-	if existingImage != nil {
-		// Hash already exists, update existing record like ImageUpdateHandler
-		image = existingImage
-
-		// Update fields if provided
-		if name != "" {
-			image.Name = name
-		}
-		if remark != "" {
-			image.Remark = remark
-		}
-		if len(tags) > 0 {
-			image.Tags = tags
-		}
+	results := service.UploadService.IngestBatch(files, common.BatchUploadOptions{
+		Remark:   c.FormValue("remark"),
+		Tags:     tags,
+		Private:  private,
+		PathTags: pathTags,
+	})
 
-		// Update image record
-		if err := service.ImageService.Update(vars.Database, image); err != nil {
-			logrus.Errorln("Failed to update existing image:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to update existing image",
-			})
-		}
+	// Build response URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
 	} else {
-		// Hash doesn't exist, create new record
-		image = &common.Image{
-			Name:        name,
-			ExtName:     extName,
-			ContentType: file.Header.Get("Content-Type"),
-			Hash:        hash,
-			FileSize:    file.Size,
-			Remark:      remark,
-			Tags:        tags,
+		baseUrl = c.BaseURL()
+	}
+	withLinks, _ := strconv.ParseBool(c.FormValue("links"))
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+		if result.Image == nil {
+			continue
 		}
-
-		// FillModel to set paths
-		service.ImageService.FillModel(image)
-
-		// Save file to local path
-		if err := utils.SaveMultipartFile(file, image.LocalPath); err != nil {
-			logrus.Errorln("Failed to save file:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to save file",
-			})
+		if result.Image.URL != "" {
+			result.Image.URL = baseUrl + result.Image.URL
 		}
-
-		// Add to database
-		if err := service.ImageService.Add(vars.Database, image); err != nil {
-			logrus.Errorln("Failed to save image record:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to save image record",
-			})
+		if withLinks {
+			service.LinkFormatService.Fill(result.Image, baseUrl)
 		}
 	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"results":      results,
+		"created":      counts[common.BATCH_STATUS_CREATED],
+		"deduplicated": counts[common.BATCH_STATUS_DEDUPLICATED],
+		"rejected":     counts[common.BATCH_STATUS_REJECTED],
+	})
+}
+
+func ImageFetchHandler(c *fiber.Ctx) error {
+	var req struct {
+		URLs    []string `json:"urls"`
+		Remark  string   `json:"remark"`
+		Tags    []string `json:"tags"`
+		Private bool     `json:"private"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > common.FETCH_MAX_URLS {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "urls must contain 1 to " + strconv.Itoa(common.FETCH_MAX_URLS) + " items",
+		})
+	}
+	for i, tag := range req.Tags {
+		req.Tags[i] = strings.TrimSpace(tag)
+	}
 
 	// Build response URL
 	var baseUrl string
@@ -133,18 +182,50 @@ func ImageUploadHandler(c *fiber.Ctx) error {
 	} else {
 		baseUrl = c.BaseURL()
 	}
-	if image.URL != "" {
-		image.URL = baseUrl + image.URL
-	}
-	// Async convert to avif/webp
-	if lo.Contains(vars.AutoConvFormat, common.IMAGE_TYPE_AVIF) {
-		vars.ImageConverter.Convert(image.LocalPath, utils.ChangeExtName(image.LocalPath, "avif"))
-	}
-	if lo.Contains(vars.AutoConvFormat, common.IMAGE_TYPE_WEBP) {
-		vars.ImageConverter.Convert(image.LocalPath, utils.ChangeExtName(image.LocalPath, "webp"))
-	}
+
+	// 少量并发抓取，单个地址失败不影响其它地址，整批共用一个总超时
+	ctx, cancel := context.WithTimeout(c.Context(), common.FETCH_BATCH_TIMEOUT)
+	defer cancel()
+	results := make([]fiber.Map, len(req.URLs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(common.FETCH_WORKERS, len(req.URLs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				rawURL := req.URLs[i]
+				image, created, err := service.UploadService.IngestURL(ctx, strings.TrimSpace(rawURL), common.UploadFile{
+					Remark:  req.Remark,
+					Tags:    req.Tags,
+					Private: req.Private,
+				})
+				if err != nil {
+					logrus.Warnf("Failed to fetch image from %s: %v", rawURL, err)
+					results[i] = fiber.Map{
+						"url":   rawURL,
+						"error": err.Error(),
+					}
+					continue
+				}
+				if image.URL != "" {
+					image.URL = baseUrl + image.URL
+				}
+				results[i] = fiber.Map{
+					"url":     rawURL,
+					"image":   image,
+					"created": created,
+				}
+			}
+		}()
+	}
+	for i := range req.URLs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"image": image,
+		"results": results,
 	})
 }
 
@@ -197,6 +278,7 @@ func ImageListHandler(c *fiber.Ctx) error {
 	// Get query parameters
 	keyword := c.Query("keyword")
 	imageTag := c.Query("tag")
+	sort := c.Query("sort") // newest, oldest, views, bandwidth, last_accessed
 	pageStr := c.Query("page", "1")
 	pageSizeStr := c.Query("pageSize", "20")
 
@@ -213,7 +295,7 @@ func ImageListHandler(c *fiber.Ctx) error {
 	}
 
 	// Search images
-	images, total, err := service.ImageService.Search(vars.Database, keyword, page, pageSize, imageTag)
+	images, total, err := service.ImageService.Search(vars.Database, keyword, page, pageSize, imageTag, sort)
 	if err != nil {
 		logrus.Errorln("Failed to search images:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -229,10 +311,14 @@ func ImageListHandler(c *fiber.Ctx) error {
 		baseUrl = c.BaseURL()
 	}
 
+	withLinks := c.QueryBool("links")
 	for _, image := range images {
 		if image.URL != "" {
 			image.URL = baseUrl + image.URL
 		}
+		if withLinks {
+			service.LinkFormatService.Fill(image, baseUrl)
+		}
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -285,6 +371,9 @@ func ImageDetailHandler(c *fiber.Ctx) error {
 	if image.URL != "" {
 		image.URL = baseUrl + image.URL
 	}
+	if c.QueryBool("links") {
+		service.LinkFormatService.Fill(image, baseUrl)
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"image": image,
@@ -324,9 +413,10 @@ func ImageUpdateHandler(c *fiber.Ctx) error {
 
 	// Parse request body
 	var updateData struct {
-		Name   *string  `json:"name"`
-		Remark *string  `json:"remark"`
-		Tags   []string `json:"tags"`
+		Name    *string  `json:"name"`
+		Remark  *string  `json:"remark"`
+		Private *bool    `json:"private"`
+		Tags    []string `json:"tags"`
 	}
 
 	if err := c.BodyParser(&updateData); err != nil {
@@ -343,6 +433,9 @@ func ImageUpdateHandler(c *fiber.Ctx) error {
 	if updateData.Remark != nil {
 		image.Remark = *updateData.Remark
 	}
+	if updateData.Private != nil {
+		image.Private = *updateData.Private
+	}
 	if updateData.Tags != nil {
 		// Clean up tags
 		for i, tag := range updateData.Tags {
@@ -390,3 +483,51 @@ func ImageTagListHandler(c *fiber.Ctx) error {
 		"tags": tags,
 	})
 }
+
+func ImageSignHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid id format",
+		})
+	}
+
+	var req struct {
+		Expire int64 `json:"expire"` // 有效期(秒)
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+	if req.Expire <= 0 {
+		req.Expire = 3600
+	}
+
+	image, err := service.ImageService.PureGet(vars.Database, id)
+	if err != nil {
+		logrus.Errorln("Failed to get image for sign:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to get image",
+		})
+	}
+	if image == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "image not found",
+		})
+	}
+
+	// Build response URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+
+	expireTime := time.Now().Unix() + req.Expire
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"url":         baseUrl + service.ImageService.SignedURL(image, expireTime),
+		"expire_time": expireTime,
+	})
+}
@@ -37,6 +37,11 @@ func UpdateSettingHandler(c *fiber.Ctx) error {
 			}
 			req[k] = string(b)
 		}
+		if err := service.SettingService.CheckJSON(k, v); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid setting " + k + ": " + err.Error(),
+			})
+		}
 	}
 	if err := service.SettingService.BulkSet(req); err != nil {
 		return err
@@ -47,6 +52,17 @@ func UpdateSettingHandler(c *fiber.Ctx) error {
 	if _, ok := req[common.SETTING_KEY_SITE_NAME]; ok {
 		vars.SiteName = req[common.SETTING_KEY_SITE_NAME]
 	}
+	if v, ok := req[common.SETTING_KEY_VARIANT_MIN_SAVING]; ok {
+		vars.VariantMinSaving = service.ParseVariantMinSaving(v)
+	}
+	if v, ok := req[common.SETTING_KEY_STAT_RETENTION_DAY]; ok {
+		vars.StatRetentionDay = service.ParseStatRetentionDay(v)
+	}
+	for k, v := range req {
+		if err := service.SettingService.ApplyJSON(k, v); err != nil {
+			return err
+		}
+	}
 
 	// 动态更新自动转换格式设置
 	needUpdateAutoConv := false
@@ -0,0 +1,141 @@
+package adminapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+func ShareListHandler(c *fiber.Ctx) error {
+	links, err := service.ShareService.List(vars.Database)
+	if err != nil {
+		logrus.Errorln("Failed to list share links:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list share links",
+		})
+	}
+
+	// Build response URLs
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	for _, link := range links {
+		if link.URL != "" {
+			link.URL = baseUrl + link.URL
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"shares": links,
+	})
+}
+
+func ShareCreateHandler(c *fiber.Ctx) error {
+	var req struct {
+		ImageID  int64  `json:"image_id"`
+		TagName  string `json:"tag_name"`
+		Password string `json:"password"`
+		Expire   int64  `json:"expire"` // 有效期(秒)，0 为永不过期
+		MaxViews int64  `json:"max_views"`
+		Remark   string `json:"remark"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	link := &common.ShareLink{
+		MaxViews: max(req.MaxViews, 0),
+		Remark:   req.Remark,
+	}
+	switch {
+	case req.ImageID > 0:
+		image, err := service.ImageService.PureGet(vars.Database, req.ImageID)
+		if err != nil {
+			logrus.Errorln("Failed to get image for share:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to get image",
+			})
+		}
+		if image == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "image not found",
+			})
+		}
+		link.TargetType = common.SHARE_TARGET_IMAGE
+		link.ImageID = req.ImageID
+	case req.TagName != "":
+		link.TargetType = common.SHARE_TARGET_TAG
+		link.TagName = req.TagName
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "image_id or tag_name is required",
+		})
+	}
+	if req.Expire > 0 {
+		link.ExpireTime = time.Now().Unix() + req.Expire
+	}
+
+	if err := service.ShareService.Add(vars.Database, link, req.Password); err != nil {
+		logrus.Errorln("Failed to create share link:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create share link",
+		})
+	}
+
+	// Build response URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	if link.URL != "" {
+		link.URL = baseUrl + link.URL
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"share": link,
+	})
+}
+
+func ShareRevokeHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid id format",
+		})
+	}
+	if err := service.ShareService.Revoke(vars.Database, id); err != nil {
+		logrus.Errorln("Failed to revoke share link:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to revoke share link",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func ShareDeleteHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid id format",
+		})
+	}
+	if err := service.ShareService.Delete(vars.Database, id); err != nil {
+		logrus.Errorln("Failed to delete share link:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete share link",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
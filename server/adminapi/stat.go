@@ -0,0 +1,123 @@
+package adminapi
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// StatHistoryHandler 查询历史统计，start/end 格式为 2006-01-02，默认最近 30 天
+func StatHistoryHandler(c *fiber.Ctx) error {
+	now := time.Now()
+	end, err := parseStatDate(c.Query("end"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid end date",
+		})
+	}
+	start, err := parseStatDate(c.Query("start"), end.AddDate(0, 0, -29))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid start date",
+		})
+	}
+	if start.After(end) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "start date is after end date",
+		})
+	}
+	if end.Sub(start) > 10*366*24*time.Hour {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "date range too large",
+		})
+	}
+
+	granularity := c.Query("granularity", common.STAT_GRANULARITY_DAY)
+	points, err := service.DailyStatService.History(vars.Database, start, end, granularity)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidGranularity) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		logrus.Errorln("Failed to get stat history:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to get stat history",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"start":       start.Format(common.STAT_DATE_FORMAT),
+		"end":         end.Format(common.STAT_DATE_FORMAT),
+		"granularity": granularity,
+		"points":      points,
+	})
+}
+
+func parseStatDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Date(def.Year(), def.Month(), def.Day(), 0, 0, 0, 0, time.Local), nil
+	}
+	return time.ParseInLocation(common.STAT_DATE_FORMAT, s, time.Local)
+}
+
+// StatAnalyticsHandler 查询访问来源排行，dimension 为空时返回所有维度
+func StatAnalyticsHandler(c *fiber.Ctx) error {
+	now := time.Now()
+	end, err := parseStatDate(c.Query("end"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid end date",
+		})
+	}
+	start, err := parseStatDate(c.Query("start"), end.AddDate(0, 0, -6))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid start date",
+		})
+	}
+	if start.After(end) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "start date is after end date",
+		})
+	}
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	dimensions := service.AnalyticsDimensions
+	if dimension := c.Query("dimension"); dimension != "" {
+		if !slices.Contains(service.AnalyticsDimensions, dimension) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid dimension",
+			})
+		}
+		dimensions = []string{dimension}
+	}
+
+	result := make(fiber.Map, len(dimensions))
+	for _, dimension := range dimensions {
+		items, err := service.AnalyticsService.Top(vars.Database, dimension, start, end, limit)
+		if err != nil {
+			logrus.Errorln("Failed to get analytics:", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to get analytics",
+			})
+		}
+		result[dimension] = items
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"start":      start.Format(common.STAT_DATE_FORMAT),
+		"end":        end.Format(common.STAT_DATE_FORMAT),
+		"geoip":      vars.GeoIPReader != nil,
+		"dimensions": result,
+	})
+}
@@ -0,0 +1,208 @@
+package adminapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+const tusContentType = "application/offset+octet-stream"
+
+// TusOptionsHandler 返回服务端支持的 tus 版本和扩展
+func TusOptionsHandler(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", common.TUS_VERSION)
+	c.Set("Tus-Version", common.TUS_VERSION)
+	c.Set("Tus-Extension", "creation,creation-with-upload,expiration,termination")
+	c.Set("Tus-Max-Size", strconv.Itoa(common.MAX_IMAGE_SIZE))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusCreateHandler 创建上传，metadata 支持 filename、filetype、tags、remark、private
+func TusCreateHandler(c *fiber.Ctx) error {
+	if ok, err := checkTusResumable(c); !ok {
+		return err
+	}
+	if c.Get("Upload-Defer-Length") != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Upload-Defer-Length is not supported",
+		})
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid Upload-Length",
+		})
+	}
+	if length > common.MAX_IMAGE_SIZE {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "file size exceeds limit",
+		})
+	}
+	upload, err := service.TusService.Create(length, c.Get("Upload-Metadata"))
+	if err != nil {
+		if errors.Is(err, service.ErrTusInvalidMeta) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return err
+	}
+	// creation-with-upload：创建请求中可以直接带上第一段数据，中断时仍返回已创建的上传
+	if c.Request().Header.ContentLength() != 0 && c.Get(fiber.HeaderContentType) == tusContentType {
+		if upload, err = appendTusBody(c, upload.ID, 0); err != nil && !errors.Is(err, service.ErrTusInterrupted) {
+			return tusError(c, err)
+		}
+	}
+	setTusUploadHeaders(c, upload)
+	c.Set(fiber.HeaderLocation, "/admin-api/tus/"+upload.ID)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// TusHeadHandler 查询上传进度，客户端据此从中断处继续
+func TusHeadHandler(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", common.TUS_VERSION)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	upload, err := service.TusService.Get(c.Params("id"))
+	if err != nil {
+		if errors.Is(err, service.ErrTusNotFound) {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		return err
+	}
+	setTusUploadHeaders(c, upload)
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.RawMeta != "" {
+		c.Set("Upload-Metadata", upload.RawMeta)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// TusPatchHandler 从指定偏移继续写入数据
+func TusPatchHandler(c *fiber.Ctx) error {
+	if ok, err := checkTusResumable(c); !ok {
+		return err
+	}
+	if c.Get(fiber.HeaderContentType) != tusContentType {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Content-Type must be " + tusContentType,
+		})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid Upload-Offset",
+		})
+	}
+	upload, err := appendTusBody(c, c.Params("id"), offset)
+	if err != nil {
+		return tusError(c, err)
+	}
+	setTusUploadHeaders(c, upload)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusDeleteHandler 终止上传并删除已接收的数据
+func TusDeleteHandler(c *fiber.Ctx) error {
+	if ok, err := checkTusResumable(c); !ok {
+		return err
+	}
+	if err := service.TusService.Delete(c.Params("id")); err != nil {
+		return tusError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TusStatusHandler 以 JSON 返回上传状态，完成后包含入库的图片
+func TusStatusHandler(c *fiber.Ctx) error {
+	upload, err := service.TusService.Get(c.Params("id"))
+	if err != nil {
+		return tusError(c, err)
+	}
+	result := fiber.Map{
+		"upload": upload,
+	}
+	if upload.ImageID != 0 {
+		image, err := service.ImageService.Get(vars.Database, upload.ImageID)
+		if err != nil {
+			return err
+		}
+		if image != nil {
+			// Build response URL
+			var baseUrl string
+			if vars.BaseURL != "" {
+				baseUrl = vars.BaseURL
+			} else {
+				baseUrl = c.BaseURL()
+			}
+			image.URL = baseUrl + image.URL
+			result["image"] = image
+		}
+	}
+	return c.JSON(result)
+}
+
+// appendTusBody 将请求体以流的方式写入上传，不经过 c.Body()
+// 连接中断时 c.Body() 会得到错误信息而不是数据，且整段数据都会丢失
+func appendTusBody(c *fiber.Ctx, id string, offset int64) (*common.TusUpload, error) {
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	return service.TusService.Append(id, offset, body)
+}
+
+func checkTusResumable(c *fiber.Ctx) (bool, error) {
+	c.Set("Tus-Resumable", common.TUS_VERSION)
+	if c.Get("Tus-Resumable") != common.TUS_VERSION {
+		c.Set("Tus-Version", common.TUS_VERSION)
+		return false, c.SendStatus(fiber.StatusPreconditionFailed)
+	}
+	return true, nil
+}
+
+func setTusUploadHeaders(c *fiber.Ctx, upload *common.TusUpload) {
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Expires", time.Unix(upload.ExpireTime, 0).UTC().Format(http.TimeFormat))
+	if upload.ImageID != 0 {
+		c.Set("Upload-Image-Id", strconv.FormatInt(upload.ImageID, 10))
+	}
+}
+
+func tusError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrTusNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrTusOffsetMismatch):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrTusExceedsLength):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, service.ErrTusInterrupted):
+		// 已写入的部分已保存，客户端通过 HEAD 获取新的偏移后继续
+		logrus.Warnln("Tus upload interrupted:", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": service.ErrTusInterrupted.Error(),
+		})
+	case errors.Is(err, service.ErrNotImage), errors.Is(err, service.ErrFileTooLarge):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	logrus.Errorln("Failed to process tus upload:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to process upload",
+	})
+}
@@ -0,0 +1,58 @@
+package adminapi
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/service"
+)
+
+func GetVariantJobHandler(c *fiber.Ctx) error {
+	return c.JSON(service.VariantJobService.GetState())
+}
+
+func StartVariantJobHandler(c *fiber.Ctx) error {
+	var filter common.VariantJobFilter
+	if err := c.BodyParser(&filter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+	// 格式会用作文件扩展名，只接受支持转换的格式
+	for i, format := range filter.Formats {
+		if !strings.HasPrefix(format, "image/") {
+			format = "image/" + format
+		}
+		if format != common.IMAGE_TYPE_WEBP && format != common.IMAGE_TYPE_AVIF {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "unsupported format: " + filter.Formats[i],
+			})
+		}
+		filter.Formats[i] = format
+	}
+	if err := service.VariantJobService.Start(filter); err != nil {
+		if errors.Is(err, service.ErrVariantJobRunning) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return err
+	}
+	return c.JSON(service.VariantJobService.GetState())
+}
+
+func ResumeVariantJobHandler(c *fiber.Ctx) error {
+	if err := service.VariantJobService.Resume(); err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(service.VariantJobService.GetState())
+}
+
+func PauseVariantJobHandler(c *fiber.Ctx) error {
+	service.VariantJobService.Pause()
+	return c.SendStatus(fiber.StatusNoContent)
+}
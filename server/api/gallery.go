@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+func GalleryTagListHandler(c *fiber.Ctx) error {
+	tags, err := service.GalleryService.Tags(vars.Database)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+	return c.JSON(fiber.Map{
+		"tags": tags,
+	})
+}
+
+func GalleryImageListHandler(c *fiber.Ctx) error {
+	name, _ := url.PathUnescape(c.Params("tag"))
+	tag, ok := service.GalleryService.Find(name)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "gallery not found",
+		})
+	}
+	page := max(c.QueryInt("page", 1), 1)
+	pageSize := c.QueryInt("pageSize", service.GalleryService.PageSize())
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = service.GalleryService.PageSize()
+	}
+	images, total, err := service.GalleryService.Images(vars.Database, tag.Name, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	// Build response URLs
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	items := make([]fiber.Map, len(images))
+	for i, image := range images {
+		items[i] = galleryImage(image, baseUrl)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+	return c.JSON(fiber.Map{
+		"tag":      tag,
+		"images":   items,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+// galleryImage 画廊中公开的图片字段，不包含数据库 ID、存储路径等内部信息
+func galleryImage(image *common.Image, baseUrl string) fiber.Map {
+	return fiber.Map{
+		"name":          image.Name,
+		"remark":        image.Remark,
+		"width":         max(image.Width, 0),
+		"height":        max(image.Height, 0),
+		"content_type":  image.ContentType,
+		"file_size":     image.FileSize,
+		"create_time":   image.CreateTime,
+		"url":           baseUrl + image.URL,
+		"thumbnail_url": baseUrl + service.GalleryService.ThumbnailURL(image),
+		"page_url":      baseUrl + service.GalleryService.PageURL(image),
+	}
+}
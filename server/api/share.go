@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// GetShareHandler GET 只返回分享是否需要密码，不计入访问次数；POST 查看分享并返回图片
+func GetShareHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if c.Method() != fiber.MethodPost {
+		link, err := service.ShareService.GetByCode(vars.Database, c.Params("code"))
+		if err != nil {
+			if errors.Is(err, service.ErrShareNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "分享不存在或已失效",
+				})
+			}
+			return err
+		}
+		return c.JSON(fiber.Map{
+			"need_password": link.HasPassword,
+			"expire_time":   link.ExpireTime,
+		})
+	}
+
+	// 密码只从请求头或 POST 请求体读取，避免出现在访问日志和 Referer 中
+	password := c.Get("X-Share-Password")
+	if password == "" {
+		var req struct {
+			Password string `json:"password" form:"password"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid request body",
+			})
+		}
+		password = req.Password
+	}
+	link, err := service.ShareService.Access(vars.Database, c.Params("code"), password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrShareNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "分享不存在或已失效",
+			})
+		case errors.Is(err, service.ErrSharePassword):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error":         "需要密码",
+				"need_password": true,
+			})
+		case errors.Is(err, service.ErrShareWrongPassword):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":         "密码错误",
+				"need_password": true,
+			})
+		}
+		return err
+	}
+
+	images, err := service.ShareService.Images(vars.Database, link)
+	if err != nil {
+		return err
+	}
+
+	// Build response URLs
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	link.URL = baseUrl + link.URL
+	for _, image := range images {
+		image.URL = baseUrl + image.URL
+		image.LocalPath = ""
+		image.RemotePath = ""
+		image.OriginalPath = ""
+	}
+
+	return c.JSON(fiber.Map{
+		"share":  link,
+		"images": images,
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// CompatUploadHandler 兼容 ShareX、PicGo、uPic 等上传工具的上传接口
+// 文件字段名默认为 file，找不到时使用表单中的第一个文件；上传的图片均为公开图片
+// 响应中 result 为 PicGo 使用的地址列表，url 供 ShareX({json:url})和 uPic 读取
+func CompatUploadHandler(c *fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return compatUploadError(c, fiber.StatusBadRequest, "invalid multipart form")
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		for _, fieldFiles := range form.File {
+			files = append(files, fieldFiles...)
+		}
+	}
+	if len(files) == 0 {
+		return compatUploadError(c, fiber.StatusBadRequest, "file parameter is required")
+	}
+	file := files[0]
+	if file.Size > common.MAX_IMAGE_SIZE {
+		return compatUploadError(c, fiber.StatusBadRequest, "file size exceeds limit")
+	}
+	data, err := utils.ReadMultipartFile(file)
+	if err != nil {
+		logrus.Errorln("Failed to read uploaded file:", err)
+		return compatUploadError(c, fiber.StatusInternalServerError, "failed to read file")
+	}
+
+	// Parse tags
+	var tags []string
+	tagsStr := c.FormValue("tags")
+	if tagsStr != "" {
+		tags = strings.Split(tagsStr, ",")
+		for i, tag := range tags {
+			tags[i] = strings.TrimSpace(tag)
+		}
+	}
+
+	// 截图工具给出的类型不一定准确，优先按内容判断
+	contentType := utils.DetectImageType(data)
+	if contentType == "" {
+		contentType = file.Header.Get("Content-Type")
+	}
+	image, _, err := service.UploadService.Ingest(&common.UploadFile{
+		Filename:    file.Filename,
+		ContentType: contentType,
+		Data:        data,
+		Remark:      c.FormValue("remark"),
+		Tags:        tags,
+		// 私有图片的地址无法公开访问，签名地址又会过期，不适合贴到其它地方
+		RejectPrivateDuplicate: true,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrNotImage) || errors.Is(err, service.ErrFileTooLarge) {
+			return compatUploadError(c, fiber.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, service.ErrPrivateDuplicate) {
+			return compatUploadError(c, fiber.StatusConflict, err.Error())
+		}
+		logrus.Errorln("Failed to save uploaded image:", err)
+		return compatUploadError(c, fiber.StatusInternalServerError, "failed to save image")
+	}
+
+	// Build response URL
+	var baseUrl string
+	if vars.BaseURL != "" {
+		baseUrl = vars.BaseURL
+	} else {
+		baseUrl = c.BaseURL()
+	}
+	imageURL := baseUrl + image.URL
+	image.URL = imageURL
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":  true,
+		"result":   []string{imageURL},
+		"url":      imageURL,
+		"page_url": baseUrl + service.GalleryService.PageURL(image),
+		"image":    image,
+	})
+}
+
+// compatUploadError 上传工具通过 success 判断结果，ShareX 从 error 读取错误信息
+func compatUploadError(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+const (
+	apiTokenLocalsKey      = "api_token"
+	apiTokenErrorLocalsKey = "api_token_error"
+)
+
+// isAdminRequest 判断请求是否携带有效的管理员令牌，令牌位置与 /admin-api 中间件一致
+// 拥有 read 权限的 API 令牌同样可以查看私有图片
+func isAdminRequest(c *fiber.Ctx) bool {
+	if vars.SkipAuth {
+		return true
+	}
+	if token := bearerToken(c); strings.HasPrefix(token, common.API_TOKEN_PREFIX) {
+		apiToken, err := service.APITokenService.Authenticate(vars.Database, token, clientIP(c))
+		return err == nil && apiToken.HasScope(common.API_TOKEN_SCOPE_READ)
+	}
+	token := requestToken(c)
+	// API 令牌只能放在 Authorization 头中，避免出现在日志、Referer 或浏览器 cookie 里
+	if strings.HasPrefix(token, common.API_TOKEN_PREFIX) {
+		return false
+	}
+	return service.AuthService.ValidateJWT(token)
+}
+
+// bearerToken 取出 Authorization 头中的令牌
+func bearerToken(c *fiber.Ctx) string {
+	if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requestToken 按 Authorization 头、token 参数、cookie 的顺序取出令牌
+func requestToken(c *fiber.Ctx) string {
+	token := c.Query("token")
+	if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if token == "" {
+		token = c.Cookies("momoka_token")
+	}
+	return token
+}
+
+// authAPIToken 校验 Authorization 头中的 API 令牌，通过时保存到 Locals 供 requireScope 检查权限
+// 返回 false 时由 JWT 中间件继续校验，API 令牌的错误原因保存在 Locals 中
+func authAPIToken(c *fiber.Ctx) bool {
+	token := bearerToken(c)
+	if !strings.HasPrefix(token, common.API_TOKEN_PREFIX) {
+		if strings.HasPrefix(requestToken(c), common.API_TOKEN_PREFIX) {
+			c.Locals(apiTokenErrorLocalsKey, service.ErrAPITokenNotInHeader)
+		}
+		return false
+	}
+	apiToken, err := service.APITokenService.Authenticate(vars.Database, token, clientIP(c))
+	if err != nil {
+		if !errors.Is(err, service.ErrAPITokenInvalid) && !errors.Is(err, service.ErrAPITokenIPDenied) {
+			logrus.Errorln("Failed to authenticate api token:", err)
+			err = service.ErrAPITokenInvalid
+		}
+		c.Locals(apiTokenErrorLocalsKey, err)
+		return false
+	}
+	c.Locals(apiTokenLocalsKey, apiToken)
+	return true
+}
+
+// adminAuthError /admin-api 认证失败时的响应
+func adminAuthError(c *fiber.Ctx, _ error) error {
+	if err, ok := c.Locals(apiTokenErrorLocalsKey).(error); ok {
+		status := fiber.StatusUnauthorized
+		if errors.Is(err, service.ErrAPITokenIPDenied) {
+			status = fiber.StatusForbidden
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": "密钥不可用或已过期",
+	})
+}
+
+// requireScope 检查 API 令牌是否拥有接口所需的权限，登录的管理员不受限制
+func requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if apiToken, ok := c.Locals(apiTokenLocalsKey).(*common.APIToken); ok && !apiToken.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": service.ErrAPITokenForbidden.Error(),
+			})
+		}
+		return c.Next()
+	}
+}
+
+// requireAPIToken 只接受 API 令牌的接口，供 ShareX、PicGo 等桌面工具使用
+func requireAPIToken(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !authAPIToken(c) {
+			err, ok := c.Locals(apiTokenErrorLocalsKey).(error)
+			if !ok {
+				err = service.ErrAPITokenInvalid
+			}
+			status := fiber.StatusUnauthorized
+			if errors.Is(err, service.ErrAPITokenIPDenied) {
+				status = fiber.StatusForbidden
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		if apiToken := c.Locals(apiTokenLocalsKey).(*common.APIToken); !apiToken.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"error":   service.ErrAPITokenForbidden.Error(),
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+)
+
+// largeBodyPaths 允许超过单张图片大小的请求路径及其请求体上限
+var largeBodyPaths = map[string]int{
+	"/admin-api/image/batch": common.MAX_BODY_SIZE,
+}
+
+// bodyLimitMiddleware 按路径限制请求体大小
+// 服务端以流的方式读取请求体，这里在交给接口前检查声明的长度，
+// 没有长度的分块请求体在普通接口读入内存检查，在大请求体接口直接拒绝，tus 上传除外
+func bodyLimitMiddleware(c *fiber.Ctx) error {
+	limit := common.MAX_IMAGE_SIZE
+	if l, ok := largeBodyPaths[strings.TrimSuffix(c.Path(), "/")]; ok && c.Method() == fiber.MethodPost {
+		limit = l
+	}
+	req := c.Request()
+	length := req.Header.ContentLength()
+	if length > limit {
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+	stream := req.BodyStream()
+	// tus 上传按声明的 Upload-Length 限制写入量，自行读取请求体
+	if length >= 0 || stream == nil || strings.HasPrefix(c.Path(), "/admin-api/tus") {
+		return c.Next()
+	}
+	if limit > common.MAX_IMAGE_SIZE {
+		c.Context().SetConnectionClose()
+		return fiber.ErrLengthRequired
+	}
+	data, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+	if err != nil {
+		c.Context().SetConnectionClose()
+		return fiber.ErrBadRequest
+	}
+	if len(data) > limit {
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+	req.SetBody(data)
+	req.Header.SetContentLength(len(data))
+	return c.Next()
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/metrics"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// s3DownloadWait 缓存未命中时等待 S3 下载名额的最长时间
+const s3DownloadWait = 10 * time.Second
+
+// recordLimitHit 记录一次触发限流，kind 为 request/bandwidth/s3_download
+func recordLimitHit(kind string) {
+	service.ImageCounterService.IncrLimited()
+	metrics.LimitHits.WithLabelValues(kind).Inc()
+}
+
+// checkRequestLimit 检查请求频率，超出限制时写入响应并返回 false
+func checkRequestLimit(c *fiber.Ctx, ip string) (bool, error) {
+	if service.DeliveryLimitService.AllowRequest(ip) {
+		return true, nil
+	}
+	recordLimitHit("request")
+	c.Set(fiber.HeaderRetryAfter, "1")
+	return false, c.SendStatus(fiber.StatusTooManyRequests)
+}
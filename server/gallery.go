@@ -0,0 +1,275 @@
+package server
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+var thumbnailSf utils.SingleFlight[string]
+
+// findGalleryTag 按路径参数查找公开标签
+func findGalleryTag(c *fiber.Ctx) (common.GalleryTag, bool) {
+	name, err := url.PathUnescape(c.Params("tag"))
+	if err != nil {
+		return common.GalleryTag{}, false
+	}
+	return service.GalleryService.Find(name)
+}
+
+// GalleryIndexHandler 公开画廊首页，列出全部公开标签
+func GalleryIndexHandler(c *fiber.Ctx) error {
+	tags, err := service.GalleryService.Tags(vars.Database)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return fiber.ErrNotFound
+	}
+	return renderHTML(c, "gallery.html", fiber.Map{
+		"Title": "画廊",
+		"Tags":  tags,
+	})
+}
+
+// GalleryPageHandler 公开标签的图片列表页
+func GalleryPageHandler(c *fiber.Ctx) error {
+	tag, ok := findGalleryTag(c)
+	if !ok {
+		return fiber.ErrNotFound
+	}
+	page := max(c.QueryInt("page", 1), 1)
+	pageSize := service.GalleryService.PageSize()
+	images, total, err := service.GalleryService.Images(vars.Database, tag.Name, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	type galleryItem struct {
+		Name         string
+		PageURL      string
+		ThumbnailURL string
+	}
+	items := make([]galleryItem, len(images))
+	for i, image := range images {
+		items[i] = galleryItem{
+			Name:         image.Name,
+			PageURL:      service.GalleryService.PageURL(image),
+			ThumbnailURL: service.GalleryService.ThumbnailURL(image),
+		}
+	}
+	baseUrl := siteBaseURL(c)
+	tagPath := "/g/" + url.PathEscape(tag.Name)
+	data := fiber.Map{
+		"Title":  tag.DisplayTitle(),
+		"Tag":    tag,
+		"Images": items,
+		"Total":  total,
+		"Meta": &pageMeta{
+			Title:       tag.DisplayTitle(),
+			Description: tag.Description,
+			PageURL:     baseUrl + tagPath,
+			RSSURL:      baseUrl + tagPath + "/rss.xml",
+			AtomURL:     baseUrl + tagPath + "/atom.xml",
+		},
+	}
+	if page > 1 {
+		data["PrevURL"] = tagPath + "?page=" + strconv.Itoa(page-1)
+	}
+	if int64(page*pageSize) < total {
+		data["NextURL"] = tagPath + "?page=" + strconv.Itoa(page+1)
+	}
+	return renderHTML(c, "gallery.html", data)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        string       `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Description string       `xml:"description"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// GalleryFeedHandler 公开标签最新图片的 RSS/Atom 订阅源
+func GalleryFeedHandler(c *fiber.Ctx) error {
+	tag, ok := findGalleryTag(c)
+	if !ok {
+		return fiber.ErrNotFound
+	}
+	images, _, err := service.GalleryService.Images(vars.Database, tag.Name, 1, common.GALLERY_FEED_SIZE)
+	if err != nil {
+		return err
+	}
+
+	baseUrl := siteBaseURL(c)
+	tagURL := baseUrl + "/g/" + url.PathEscape(tag.Name)
+	title := vars.SiteName + " - " + tag.DisplayTitle()
+	// 订阅内容中的图片使用缩略图，点击进入查看页
+	content := func(image *common.Image, pageURL string) string {
+		body := `<a href="` + template.HTMLEscapeString(pageURL) + `"><img src="` +
+			template.HTMLEscapeString(baseUrl+service.GalleryService.ThumbnailURL(image)) + `" alt="` + template.HTMLEscapeString(image.Name) + `"></a>`
+		if image.Remark != "" {
+			body += "<p>" + template.HTMLEscapeString(image.Remark) + "</p>"
+		}
+		return body
+	}
+
+	var body any
+	if strings.HasSuffix(c.Path(), "/atom.xml") {
+		c.Set(fiber.HeaderContentType, "application/atom+xml; charset=utf-8")
+		updated := time.Now()
+		if len(images) > 0 {
+			updated = time.Unix(images[0].CreateTime, 0)
+		}
+		feed := atomFeed{
+			Title:   title,
+			ID:      tagURL,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: tagURL},
+				{Href: tagURL + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+			},
+		}
+		for _, image := range images {
+			pageURL := baseUrl + service.GalleryService.PageURL(image)
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   image.Name,
+				ID:      pageURL,
+				Updated: time.Unix(image.CreateTime, 0).UTC().Format(time.RFC3339),
+				Links: []atomLink{
+					{Href: pageURL},
+					{Href: baseUrl + image.URL, Rel: "enclosure", Type: image.ContentType},
+				},
+				Content: atomContent{Type: "html", Body: content(image, pageURL)},
+			})
+		}
+		body = feed
+	} else {
+		c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:       title,
+				Link:        tagURL,
+				Description: tag.Description,
+			},
+		}
+		for _, image := range images {
+			pageURL := baseUrl + service.GalleryService.PageURL(image)
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       image.Name,
+				Link:        pageURL,
+				GUID:        pageURL,
+				PubDate:     time.Unix(image.CreateTime, 0).UTC().Format(time.RFC1123Z),
+				Description: content(image, pageURL),
+				Enclosure:   rssEnclosure{URL: baseUrl + image.URL, Type: image.ContentType, Length: image.FileSize},
+			})
+		}
+		body = feed
+	}
+
+	data, err := xml.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Send(append([]byte(xml.Header), data...))
+}
+
+// ThumbnailHandler 公开图片的缩略图，首次访问时生成并缓存在本地
+func ThumbnailHandler(c *fiber.Ctx) error {
+	ip := clientIP(c)
+	if allowed, err := checkRequestLimit(c, ip); !allowed {
+		return err
+	}
+	// 缩略图同样受防盗链限制，否则可以绕过原图的来源检查
+	if allowed, err := checkHotlink(c); !allowed {
+		return err
+	}
+	imageHashId := strings.TrimSuffix(c.Params("filename"), ".webp")
+	image, err := loadImageByHashId(imageHashId)
+	if err != nil {
+		return err
+	}
+	if image == nil || image.Private {
+		return fiber.ErrNotFound
+	}
+
+	thumbPath := service.GalleryService.ThumbnailPath(image)
+	if !utils.FileExists(thumbPath) {
+		if err = ensureImageCached(c, image); err != nil {
+			return err
+		}
+		_, err = thumbnailSf.Do(thumbPath, func() (string, error) {
+			return thumbPath, utils.MakeThumbnail(image.LocalPath, thumbPath, common.GALLERY_THUMB_WIDTH)
+		})
+		if err != nil {
+			// 无法生成缩略图的格式直接使用原图
+			logrus.Warnf("make thumbnail for image %d failed: %v", image.ID, err)
+			return c.Redirect(image.URL, fiber.StatusFound)
+		}
+	}
+	service.CacheService.Touch(thumbPath)
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(common.DEFAULT_CACHE_MAX_AGE))
+	etag := `"` + image.Hash + "-thumb" + strconv.Itoa(common.GALLERY_THUMB_WIDTH) + `"`
+	_, err = sendImageFile(c, thumbPath, etag, time.Unix(image.CreateTime, 0), nil)
+	return err
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// hotlinkPlaceholder 防盗链拦截时返回的占位图
+const hotlinkPlaceholder = `<svg xmlns="http://www.w3.org/2000/svg" width="320" height="120" viewBox="0 0 320 120">` +
+	`<rect width="320" height="120" fill="#f2f2f2"/>` +
+	`<text x="160" y="66" font-family="sans-serif" font-size="16" fill="#888" text-anchor="middle">Image hotlinking is not allowed</text>` +
+	`</svg>`
+
+// checkHotlink 检查防盗链，被拦截时写入响应并返回 false
+func checkHotlink(c *fiber.Ctx) (bool, error) {
+	if service.HotlinkService.Allowed(c.Get(fiber.HeaderReferer), c.Hostname()) {
+		return true, nil
+	}
+	service.ImageCounterService.IncrBlocked()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	switch vars.HotlinkPolicy.Action {
+	case common.HOTLINK_ACTION_PLACEHOLDER:
+		c.Type("svg")
+		return false, c.SendString(hotlinkPlaceholder)
+	case common.HOTLINK_ACTION_REDIRECT:
+		if vars.HotlinkPolicy.RedirectURL != "" {
+			return false, c.Redirect(vars.HotlinkPolicy.RedirectURL, fiber.StatusFound)
+		}
+	}
+	return false, c.SendStatus(fiber.StatusForbidden)
+}
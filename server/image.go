@@ -2,51 +2,52 @@ package server
 
 import (
 	"errors"
+	"io"
+	"mime"
+	"net/url"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
 	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
 	"github.com/zjyl1994/momoka/infra/utils"
 	"github.com/zjyl1994/momoka/infra/vars"
 	"github.com/zjyl1994/momoka/service"
 )
 
-var getImageSf utils.SingleFlight[*common.Image]
+var (
+	getImageSf      utils.SingleFlight[*common.Image]
+	downloadImageSf utils.SingleFlight[string]
+)
 
 func GetImageHandler(c *fiber.Ctx) error {
+	// 按 IP 限制请求频率
+	ip := clientIP(c)
+	if allowed, err := checkRequestLimit(c, ip); !allowed {
+		return err
+	}
+	// 防盗链检查，拦截的请求不计入点击和带宽
+	if allowed, err := checkHotlink(c); !allowed {
+		return err
+	}
 	fileName := c.Params("filename")
+	extName := filepath.Ext(fileName)
+	imageHashId := strings.TrimSuffix(filepath.Base(fileName), extName)
 	// load image metadata from database
 	imgObject, err := getImageSf.Do(fileName, func() (*common.Image, error) {
-		extName := filepath.Ext(fileName)
-		imageHashId := strings.TrimSuffix(filepath.Base(fileName), extName)
-
+		// 无法解析的文件名视为不存在
 		imageId, err := vars.HashID.DecodeInt64WithError(imageHashId)
-		if err != nil {
-			return nil, err
-		}
-		if len(imageId) != 2 || imageId[0] != common.ENTITY_TYPE_FILE {
-			return nil, errors.New("invalid image id")
-		}
-
-		// 检查库里有没有，防止穿透到S3上产生404请求费用
-		imgObj, err := service.ImageService.PureGet(vars.Database, imageId[1])
-		if err != nil {
-			return nil, err
-		}
-		if imgObj == nil {
+		if err != nil || len(imageId) != 2 || imageId[0] != common.ENTITY_TYPE_FILE {
 			return nil, nil
 		}
 
-		// 加载图片实际路径
-		if !utils.FileExists(imgObj.LocalPath) {
-			// 从S3下载
-			err = service.ImageService.Download(imgObj)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return imgObj, nil
+		// 检查库里有没有，防止穿透到S3上产生404请求费用
+		return service.ImageService.CachedGet(vars.Database, imageId[1])
 	})
 
 	if err != nil {
@@ -55,26 +56,151 @@ func GetImageHandler(c *fiber.Ctx) error {
 	if imgObject == nil || len(imgObject.LocalPath) == 0 {
 		return fiber.ErrNotFound
 	}
+	// 私有图片需要有效签名或管理员令牌
+	cacheControl := "public, max-age=" + strconv.Itoa(common.DEFAULT_CACHE_MAX_AGE)
+	if imgObject.Private {
+		if service.SignService.Verify(imageHashId, c.Query("e"), c.Query("s")) {
+			expire, _ := strconv.ParseInt(c.Query("e"), 10, 64)
+			cacheControl = "private, max-age=" + strconv.FormatInt(expire-time.Now().Unix(), 10)
+		} else if isAdminRequest(c) {
+			cacheControl = "private, no-cache"
+		} else if c.Query("s") != "" {
+			return fiber.ErrForbidden
+		} else {
+			return fiber.ErrNotFound
+		}
+	}
+	// 加载图片实际路径
+	if err = ensureImageCached(c, imgObject); err != nil {
+		return err
+	}
 	// 处理自动图片转换
 	localDiskPath := imgObject.LocalPath
-	if accept := c.Accepts(vars.AutoConvFormat...); accept != "" && imgObject.ContentType != accept {
+	accept := c.Accepts(vars.AutoConvFormat...)
+	// 扩展名与原图不同且为已启用的转换格式时，按扩展名指定格式，用于 <picture> 的 <source>
+	if format := mime.TypeByExtension(extName); !strings.EqualFold(extName, imgObject.ExtName) && slices.Contains(vars.AutoConvFormat, format) {
+		accept = format
+	}
+	if accept != "" && imgObject.ContentType != accept {
 		targetPath := utils.ChangeExtName(localDiskPath, strings.TrimPrefix(accept, "image/"))
 
-		if utils.FileExists(targetPath) {
+		if service.CacheService.Exists(targetPath) {
 			localDiskPath = targetPath
 		} else {
-			// 异步触发转换，本次请求仍然使用原始图片进行响应
-			vars.ImageConverter.Convert(localDiskPath, targetPath)
+			variant, err := service.ImageVariantService.Get(vars.Database, imgObject.ID, accept, "")
+			if err != nil {
+				return err
+			}
+			switch {
+			case variant == nil:
+				// 异步触发转换，本次请求仍然使用原始图片进行响应
+				vars.ImageConverter.Convert(localDiskPath, targetPath)
+			case variant.Discarded:
+				// 转换结果不比原图小，继续使用原图
+			default:
+				// 已转换过的版本从S3取回，避免重复转换；下载名额已满时本次使用原图
+				release, err := service.DeliveryLimitService.AcquireDownload(0)
+				if err != nil {
+					recordLimitHit("s3_download")
+					break
+				}
+				err = service.ImageVariantService.Download(variant, targetPath)
+				release()
+				if err != nil {
+					logrus.Errorln("download variant failed", err)
+					vars.ImageConverter.Convert(localDiskPath, targetPath)
+				} else {
+					localDiskPath = targetPath
+				}
+			}
 		}
 	}
-	// 刷新文件访问时间,方便后续清理使用
-	err = utils.TouchFile(localDiskPath)
+	// 记录文件访问时间,方便后续清理使用
+	service.CacheService.Touch(localDiskPath)
+	// 按缓存规则设置缓存控制，私有图片只允许规则禁用缓存
+	rule, err := service.CachePolicyService.Match(imgObject, mime.TypeByExtension(filepath.Ext(localDiskPath)), func() ([]string, error) {
+		return service.ImageService.CachedTags(vars.Database, imgObject.ID)
+	})
+	if err != nil {
+		return err
+	}
+	if rule != nil && (rule.NoStore || !imgObject.Private) {
+		cacheControl = service.CachePolicyService.CacheControl(rule)
+	}
+	c.Set("Cache-Control", cacheControl)
+	// 按规则或 download 参数设置 Content-Disposition
+	var disposition string
+	if rule != nil {
+		disposition = rule.Disposition
+	}
+	if c.QueryBool("download") {
+		disposition = common.CONTENT_DISPOSITION_ATTACHMENT
+	}
+	if disposition != "" {
+		c.Set(fiber.HeaderContentDisposition, contentDisposition(disposition, downloadName(imgObject, imageHashId, filepath.Ext(localDiskPath))))
+	}
+	if len(vars.AutoConvFormat) > 0 {
+		c.Vary(fiber.HeaderAccept)
+	}
+	// 强校验 ETag 由内容哈希和实际发送的格式组成
+	etag := `"` + imgObject.Hash + strings.ReplaceAll(filepath.Ext(localDiskPath), ".", "-") + `"`
+	sent, err := sendImageFile(c, localDiskPath, etag, time.Unix(imgObject.CreateTime, 0), func(r io.Reader) io.Reader {
+		// 按带宽上限限速发送
+		return service.DeliveryLimitService.ThrottleReader(r, ip, func() {
+			recordLimitHit("bandwidth")
+		})
+	})
 	if err != nil {
 		return err
 	}
-	// 记录点击次数和带宽消耗
-	service.ImageCounterService.Incr(localDiskPath)
-	// 设置客户端缓存控制
-	c.Set("Cache-Control", "public, max-age=2592000") // 公开缓存30天
-	return c.SendFile(localDiskPath)
+	// 记录点击次数和实际发送的带宽消耗
+	service.ImageCounterService.Incr(imgObject.ID, sent)
+	service.AnalyticsService.Record(c.Get(fiber.HeaderReferer), c.Get(fiber.HeaderUserAgent), strings.TrimPrefix(filepath.Ext(localDiskPath), "."), ip, sent)
+	return nil
+}
+
+// ensureImageCached 确保原图在本地缓存中，不存在时从 S3 下载
+func ensureImageCached(c *fiber.Ctx, imgObject *common.Image) error {
+	if service.CacheService.Exists(imgObject.LocalPath) {
+		metrics.ImageCache.WithLabelValues("hit").Inc()
+		return nil
+	}
+	metrics.ImageCache.WithLabelValues("miss").Inc()
+	// 从S3下载
+	_, err := downloadImageSf.Do(imgObject.LocalPath, func() (string, error) {
+		// 限制同时进行的 S3 下载数量
+		release, err := service.DeliveryLimitService.AcquireDownload(s3DownloadWait)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return imgObject.LocalPath, service.ImageService.Download(imgObject)
+	})
+	if errors.Is(err, service.ErrDownloadBusy) {
+		recordLimitHit("s3_download")
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return fiber.ErrServiceUnavailable
+	}
+	return err
+}
+
+// downloadName 下载文件名使用图片原名，扩展名与实际发送的格式一致
+// 入库时 Name 已去掉扩展名，名字中的其它点号保持原样
+func downloadName(image *common.Image, imageHashId, extName string) string {
+	name := image.Name
+	if name == "" {
+		name = imageHashId
+	}
+	return name + extName
+}
+
+// contentDisposition 生成 Content-Disposition，非 ASCII 文件名使用 RFC 5987 编码
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return disposition + `; filename="` + fallback + `"; filename*=UTF-8''` + strings.ReplaceAll(url.QueryEscape(filename), "+", "%20")
 }
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/metrics"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// sendImageFile 发送图片文件，处理条件请求和单段 Range 请求，返回实际发送的字节数
+// wrap 不为空时用于包装响应内容的读取，例如限速
+func sendImageFile(c *fiber.Ctx, path, etag string, modTime time.Time, wrap func(io.Reader) io.Reader) (int64, error) {
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if isNotModified(c, etag, modTime) {
+		c.Status(fiber.StatusNotModified)
+		return 0, nil
+	}
+
+	content, size, closer, err := openImageContent(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fiber.ErrNotFound
+		}
+		return 0, err
+	}
+	closeContent := func() {
+		if closer != nil {
+			closer.Close()
+		}
+	}
+
+	start, length, partial, ok := parseRange(c, etag, modTime, size)
+	if !ok {
+		closeContent()
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+		return 0, c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+	c.Type(strings.TrimPrefix(filepath.Ext(path), "."))
+	if partial {
+		c.Status(fiber.StatusPartialContent)
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+	}
+	if c.Method() == fiber.MethodHead {
+		closeContent()
+		c.Response().Header.SetContentLength(int(length))
+		c.Response().SkipBody = true
+		return 0, nil
+	}
+	var body io.Reader = io.NewSectionReader(content, start, length)
+	if wrap != nil {
+		body = wrap(body)
+	}
+	if closer != nil {
+		// fasthttp 在发送完成后会关闭实现了 io.Closer 的 body
+		body = &closableReader{body, closer}
+	}
+	c.Response().SetBodyStream(body, int(length))
+	return length, nil
+}
+
+// openImageContent 打开图片内容，优先使用内存缓存，适合的小文件读取后放入内存缓存
+// 内容来自内存时 closer 为 nil
+func openImageContent(path string) (io.ReaderAt, int64, io.Closer, error) {
+	if data, ok := service.CacheService.Load(path); ok {
+		metrics.MemoryCache.WithLabelValues("hit").Inc()
+		return bytes.NewReader(data), int64(len(data)), nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	size := info.Size()
+	if !service.CacheService.Admit(size) {
+		return file, size, file, nil
+	}
+	metrics.MemoryCache.WithLabelValues("miss").Inc()
+	data := make([]byte, size)
+	_, err = io.ReadFull(file, data)
+	file.Close()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	service.CacheService.Store(path, data)
+	return bytes.NewReader(data), size, nil, nil
+}
+
+// isNotModified 判断条件请求是否命中，If-None-Match 优先于 If-Modified-Since
+func isNotModified(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		return etagMatch(noneMatch, etag)
+	}
+	if modifiedSince := c.Get(fiber.HeaderIfModifiedSince); modifiedSince != "" {
+		t, err := http.ParseTime(modifiedSince)
+		if err != nil {
+			return false
+		}
+		return !modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagMatch 使用弱比较判断 ETag 列表中是否包含指定 ETag
+func etagMatch(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange 解析 Range 请求头，仅支持单段范围，多段范围和格式错误的范围按完整内容返回
+// ok 为 false 表示范围格式正确但无法满足
+func parseRange(c *fiber.Ctx, etag string, modTime time.Time, size int64) (start, length int64, partial, ok bool) {
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if rangeHeader == "" || !strings.HasPrefix(rangeHeader, "bytes=") || strings.Contains(rangeHeader, ",") {
+		return 0, size, false, true
+	}
+	// If-Range 不匹配时返回完整内容
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" && !ifRangeMatch(ifRange, etag, modTime) {
+		return 0, size, false, true
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(rangeHeader, "bytes="))
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, size, false, true
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+	if startStr == "" {
+		// bytes=-N 表示最后 N 个字节
+		n, err := strconv.ParseUint(endStr, 10, 63)
+		if err != nil {
+			return 0, size, false, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		suffix := min(int64(n), size)
+		return size - suffix, suffix, true, true
+	}
+	first, err := strconv.ParseUint(startStr, 10, 63)
+	if err != nil {
+		return 0, size, false, true
+	}
+	start = int64(first)
+	end := size - 1
+	if endStr != "" {
+		last, err := strconv.ParseUint(endStr, 10, 63)
+		if err != nil || int64(last) < start {
+			return 0, size, false, true
+		}
+		end = min(int64(last), size-1)
+	}
+	if start >= size {
+		return 0, 0, false, false
+	}
+	return start, end - start + 1, true, true
+}
+
+// ifRangeMatch 判断 If-Range 是否与当前内容一致，其值可以是 ETag 或 HTTP 日期
+func ifRangeMatch(ifRange, etag string, modTime time.Time) bool {
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(t)
+}
+
+type closableReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *closableReader) Close() error {
+	return r.closer.Close()
+}
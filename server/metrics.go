@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/subtle"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+var s3TaskStatusNames = map[int]string{
+	common.S3TASK_STATUS_WAITING: "waiting",
+	common.S3TASK_STATUS_RUNNING: "running",
+	common.S3TASK_STATUS_SUCCESS: "success",
+	common.S3TASK_STATUS_FAILED:  "failed",
+}
+
+// metricsMiddleware 记录每个路由的请求数和耗时，使用路由模板避免标签基数过高
+func metricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+	route := c.Route().Path
+	method := c.Method()
+	metrics.HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	metrics.HTTPDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	return err
+}
+
+var promHandler = adaptor.HTTPHandler(promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+// MetricsHandler 输出 Prometheus 指标，设置了令牌时需要通过 Bearer 或 token 参数提供
+func MetricsHandler(c *fiber.Ctx) error {
+	if vars.MetricsToken != "" {
+		token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(vars.MetricsToken)) != 1 {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+	}
+	updateMetrics()
+	return promHandler(c)
+}
+
+// updateMetrics 在抓取时刷新需要查询才能得到的状态指标
+func updateMetrics() {
+	if counts, err := service.S3TaskService.CountByStatus(vars.Database); err != nil {
+		logrus.Errorln("metrics count s3 tasks failed", err)
+	} else {
+		for status, name := range s3TaskStatusNames {
+			metrics.S3Tasks.WithLabelValues(name).Set(float64(counts[status]))
+		}
+	}
+	if vars.ImageConverter != nil {
+		metrics.ConverterQueue.Set(float64(vars.ImageConverter.QueueLen()))
+	}
+	fileCount, totalSize := service.CacheService.DiskStats()
+	metrics.CacheFiles.Set(float64(fileCount))
+	metrics.CacheSize.Set(float64(totalSize))
+	_, memorySize := service.CacheService.MemoryStats()
+	metrics.MemoryCacheSize.Set(float64(memorySize))
+	var dbSize int64
+	for _, name := range []string{"momoka.db", "momoka.db-wal"} {
+		if info, err := os.Stat(utils.DataPath(name)); err == nil {
+			dbSize += info.Size()
+		}
+	}
+	metrics.DatabaseSize.Set(float64(dbSize))
+}
+
+// runMetricsServer 在独立地址上提供指标，避免暴露在公网入口
+func runMetricsServer(listenAddr string) {
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+	app.Get("/metrics", MetricsHandler)
+	logrus.Infoln("Metrics is running on", listenAddr)
+	if err := app.Listen(listenAddr); err != nil {
+		logrus.Errorln("metrics server stopped", err)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// RandomImageHandler 随机返回一张图片，redirect=1 时重定向到图片地址，否则返回 JSON
+// 指定 seed 时同一天内同一 seed 的结果固定
+func RandomImageHandler(c *fiber.Ctx) error {
+	filter := common.RandomFilter{
+		Visibility:  c.Query("visibility", common.IMAGE_VISIBILITY_PUBLIC),
+		Orientation: c.Query("orientation"),
+	}
+	for _, tag := range strings.Split(c.Query("tag"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+	if !slices.Contains([]string{"", common.IMAGE_ORIENTATION_LANDSCAPE, common.IMAGE_ORIENTATION_PORTRAIT, common.IMAGE_ORIENTATION_SQUARE}, filter.Orientation) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid orientation",
+		})
+	}
+	// 私有图片仅管理员可以随机获取，all 表示不限制可见性
+	switch filter.Visibility {
+	case common.IMAGE_VISIBILITY_PUBLIC:
+	case common.IMAGE_VISIBILITY_PRIVATE, "all":
+		if !isAdminRequest(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "private images require admin token",
+			})
+		}
+		if filter.Visibility == "all" {
+			filter.Visibility = ""
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid visibility",
+		})
+	}
+
+	var r *rand.Rand
+	now := time.Now()
+	seed := c.Query("seed")
+	if seed != "" {
+		h := fnv.New64a()
+		h.Write([]byte(seed + ":" + now.Format(common.STAT_DATE_FORMAT)))
+		r = rand.New(rand.NewPCG(h.Sum64(), 0))
+	}
+	image, err := service.ImageService.Random(vars.Database, filter, r)
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "no image matched",
+		})
+	}
+
+	baseUrl := siteBaseURL(c)
+	if image.Private {
+		image.URL = service.ImageService.SignedURL(image, now.Unix()+common.SHARE_IMAGE_URL_EXPIRE)
+	}
+	image.URL = baseUrl + image.URL
+	image.LocalPath = ""
+	image.RemotePath = ""
+	image.OriginalPath = ""
+
+	// 固定 seed 的公开结果可以缓存到当天结束
+	if seed != "" && filter.Visibility == common.IMAGE_VISIBILITY_PUBLIC {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(tomorrow.Sub(now).Seconds())))
+	} else {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+	}
+	if c.QueryBool("redirect") {
+		return c.Redirect(image.URL, fiber.StatusFound)
+	}
+	return c.JSON(fiber.Map{
+		"image": image,
+	})
+}
@@ -7,6 +7,7 @@ import (
 	jwtware "github.com/gofiber/contrib/jwt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/golang-jwt/jwt/v5"
@@ -21,23 +22,63 @@ import (
 func Run(listenAddr string) error {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
-		BodyLimit:             common.MAX_IMAGE_SIZE,
+		// 请求体以流的方式读取，只有批量上传接口允许超过单张图片的大小，见 bodyLimitMiddleware
+		BodyLimit:                    common.MAX_IMAGE_SIZE,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+		// 只信任来自可信代理的代理头，否则客户端可以伪造 IP 绕过限流
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          vars.TrustedProxies,
+		ProxyHeader:             vars.ProxyHeader,
+		EnableIPValidation:      true,
 	})
 
+	app.Use(bodyLimitMiddleware)
+
+	// Prometheus 指标，配置了独立监听地址时不在主入口暴露
+	if vars.MetricsListenAddr != "" || vars.MetricsToken != "" {
+		app.Use(metricsMiddleware)
+		if vars.MetricsListenAddr != "" {
+			go runMetricsServer(vars.MetricsListenAddr)
+		} else {
+			app.Get("/metrics", MetricsHandler)
+		}
+	}
+
 	app.Get("/i/:filename", GetImageHandler)
 	app.Get("/healthz", healthCheckHandler)
+	// 分享密码按 IP 和分享码限制尝试次数
+	shareLimiter := limiter.New(limiter.Config{
+		Max:        15, // 每个 IP 对同一分享每分钟最多 15 次请求
+		Expiration: time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return clientIP(c) + "|" + c.Params("code")
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "请求过于频繁，请稍后再试。",
+			})
+		},
+	})
+
+	app.Get("/s/:code", SharePageHandler)
+	app.Post("/s/:code", shareLimiter, SharePageHandler)
+	app.Get("/s/:code/i/:filename", ShareImageHandler)
+	app.Get("/v/:hashid", ViewerPageHandler)
+	app.Get("/oembed", OEmbedHandler)
+	app.Get("/t/:filename", ThumbnailHandler)
+	app.Get("/g", GalleryIndexHandler)
+	app.Get("/g/:tag", GalleryPageHandler)
+	app.Get("/g/:tag/rss.xml", GalleryFeedHandler)
+	app.Get("/g/:tag/atom.xml", GalleryFeedHandler)
 
 	apiGroup := app.Group("/api")
 	apiGroup.Get("/bing", api.GetBingTodayImageHandler)
+	apiGroup.Get("/random", RandomImageHandler)
 	apiGroup.Post("/login", limiter.New(limiter.Config{
-		Max:        15, // 每个 IP 每分钟最多 15 次请求
-		Expiration: time.Minute,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			if ip := c.Get("X-Real-IP"); ip != "" {
-				return ip
-			}
-			return c.IP()
-		},
+		Max:          15, // 每个 IP 每分钟最多 15 次请求
+		Expiration:   time.Minute,
+		KeyGenerator: clientIP,
 		LimitReached: func(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 				"error": "请求过于频繁，请稍后再试。",
@@ -47,39 +88,76 @@ func Run(listenAddr string) error {
 	apiGroup.Post("/cap/challenge", api.CreateChallenge)
 	apiGroup.Post("/cap/redeem", api.RedeemChallenge)
 	apiGroup.Get("/auth-status", api.AuthStatusHandler)
+	apiGroup.Get("/share/:code", shareLimiter, api.GetShareHandler)
+	apiGroup.Post("/share/:code", shareLimiter, api.GetShareHandler)
+	// 公开画廊允许跨域，便于在其它站点嵌入
+	apiGroup.Get("/gallery", cors.New(), api.GalleryTagListHandler)
+	apiGroup.Get("/gallery/:tag", cors.New(), api.GalleryImageListHandler)
+	// 兼容 ShareX/PicGo/uPic 的上传接口，只接受 API 令牌
+	apiGroup.Post("/upload", requireAPIToken(common.API_TOKEN_SCOPE_UPLOAD), api.CompatUploadHandler)
 
 	adminAPI := app.Group("/admin-api", jwtware.New(jwtware.Config{
-		SigningKey:  jwtware.SigningKey{JWTAlg: jwt.SigningMethodHS256.Alg(), Key: []byte(vars.Secret)},
-		TokenLookup: "header:Authorization,query:token,cookie:momoka_token",
-		AuthScheme:  "Bearer",
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "密钥不可用或已过期",
-			})
-		},
+		SigningKey:   jwtware.SigningKey{JWTAlg: jwt.SigningMethodHS256.Alg(), Key: []byte(vars.Secret)},
+		TokenLookup:  "header:Authorization,query:token,cookie:momoka_token",
+		AuthScheme:   "Bearer",
+		ErrorHandler: adminAuthError,
 		Filter: func(c *fiber.Ctx) bool {
-			return vars.SkipAuth
+			return vars.SkipAuth || authAPIToken(c)
 		},
 	}))
+	// API 令牌只能访问权限范围内的接口，登录的管理员不受限制
+	scopeUpload := requireScope(common.API_TOKEN_SCOPE_UPLOAD)
+	scopeRead := requireScope(common.API_TOKEN_SCOPE_READ)
+	scopeDelete := requireScope(common.API_TOKEN_SCOPE_DELETE)
+	scopeAdmin := requireScope(common.API_TOKEN_SCOPE_ADMIN)
 
 	// 设置
-	adminAPI.Get("/setting", adminapi.ListSettingHandler)
-	adminAPI.Patch("/setting", adminapi.UpdateSettingHandler)
-	adminAPI.Get("/readonly-setting", adminapi.GetReadonlySettingHandler)
+	adminAPI.Get("/setting", scopeAdmin, adminapi.ListSettingHandler)
+	adminAPI.Patch("/setting", scopeAdmin, adminapi.UpdateSettingHandler)
+	adminAPI.Get("/readonly-setting", scopeAdmin, adminapi.GetReadonlySettingHandler)
 	// 统计
-	adminAPI.Get("/dashboard", adminapi.DashboardDataHandler)
+	adminAPI.Get("/dashboard", scopeAdmin, adminapi.DashboardDataHandler)
+	adminAPI.Get("/stat/history", scopeAdmin, adminapi.StatHistoryHandler)
+	adminAPI.Get("/stat/analytics", scopeAdmin, adminapi.StatAnalyticsHandler)
 	// 备份
-	adminAPI.Post("/backup/generate", adminapi.GenerateBackupHandler)
-	adminAPI.Post("/backup/restore", adminapi.RestoreBackupHandler)
-	adminAPI.Get("/backup", adminapi.ListBackupHandler)
-	adminAPI.Delete("/backup", adminapi.DeleteBackupHandler)
+	adminAPI.Post("/backup/generate", scopeAdmin, adminapi.GenerateBackupHandler)
+	adminAPI.Post("/backup/restore", scopeAdmin, adminapi.RestoreBackupHandler)
+	adminAPI.Get("/backup", scopeAdmin, adminapi.ListBackupHandler)
+	adminAPI.Delete("/backup", scopeAdmin, adminapi.DeleteBackupHandler)
 	// 图片管理
-	adminAPI.Post("/image", adminapi.ImageUploadHandler)
-	adminAPI.Delete("/image", adminapi.ImageDeleteHandler)
-	adminAPI.Get("/image", adminapi.ImageListHandler)
-	adminAPI.Get("/image/tags", adminapi.ImageTagListHandler)
-	adminAPI.Get("/image/:id", adminapi.ImageDetailHandler)
-	adminAPI.Put("/image/:id", adminapi.ImageUpdateHandler)
+	adminAPI.Post("/image", scopeUpload, adminapi.ImageUploadHandler)
+	adminAPI.Post("/image/batch", scopeUpload, adminapi.ImageBatchUploadHandler)
+	adminAPI.Post("/image/fetch", scopeUpload, adminapi.ImageFetchHandler)
+	adminAPI.Delete("/image", scopeDelete, adminapi.ImageDeleteHandler)
+	adminAPI.Get("/image", scopeRead, adminapi.ImageListHandler)
+	adminAPI.Get("/image/tags", scopeRead, adminapi.ImageTagListHandler)
+	adminAPI.Get("/image/:id", scopeRead, adminapi.ImageDetailHandler)
+	// 修改任意图片的名称、标签和可见性需要 admin 权限，upload 权限只能新增图片
+	adminAPI.Put("/image/:id", scopeAdmin, adminapi.ImageUpdateHandler)
+	adminAPI.Post("/image/:id/sign", scopeRead, adminapi.ImageSignHandler)
+	// 断点续传上传(tus 协议)，HEAD 需要在 GET 之前注册
+	adminAPI.Options("/tus", scopeUpload, adminapi.TusOptionsHandler)
+	adminAPI.Post("/tus", scopeUpload, adminapi.TusCreateHandler)
+	adminAPI.Head("/tus/:id", scopeUpload, adminapi.TusHeadHandler)
+	adminAPI.Get("/tus/:id", scopeUpload, adminapi.TusStatusHandler)
+	adminAPI.Patch("/tus/:id", scopeUpload, adminapi.TusPatchHandler)
+	adminAPI.Delete("/tus/:id", scopeUpload, adminapi.TusDeleteHandler)
+	// 分享链接
+	adminAPI.Get("/share", scopeAdmin, adminapi.ShareListHandler)
+	adminAPI.Post("/share", scopeAdmin, adminapi.ShareCreateHandler)
+	adminAPI.Post("/share/:id/revoke", scopeAdmin, adminapi.ShareRevokeHandler)
+	adminAPI.Delete("/share/:id", scopeAdmin, adminapi.ShareDeleteHandler)
+	// 衍生版本批量生成
+	adminAPI.Get("/variant/job", scopeAdmin, adminapi.GetVariantJobHandler)
+	adminAPI.Post("/variant/job", scopeAdmin, adminapi.StartVariantJobHandler)
+	adminAPI.Post("/variant/job/resume", scopeAdmin, adminapi.ResumeVariantJobHandler)
+	adminAPI.Delete("/variant/job", scopeAdmin, adminapi.PauseVariantJobHandler)
+	// API 令牌
+	adminAPI.Get("/token", scopeAdmin, adminapi.APITokenListHandler)
+	adminAPI.Post("/token", scopeAdmin, adminapi.APITokenCreateHandler)
+	adminAPI.Post("/token/sharex", scopeAdmin, adminapi.APITokenShareXHandler)
+	adminAPI.Post("/token/:id/revoke", scopeAdmin, adminapi.APITokenRevokeHandler)
+	adminAPI.Delete("/token/:id", scopeAdmin, adminapi.APITokenDeleteHandler)
 
 	app.Use("/", compress.New(compress.Config{
 		Level: compress.LevelDefault,
@@ -99,3 +177,8 @@ func healthCheckHandler(c *fiber.Ctx) error {
 		"time":   time.Now().Format(time.RFC3339),
 	})
 }
+
+// clientIP 获取客户端 IP，请求来自可信代理时使用代理头中的地址，否则为连接的对端地址
+func clientIP(c *fiber.Ctx) string {
+	return c.IP()
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// SharePageHandler 分享链接公开页面，GET 只展示查看按钮，POST 提交密码并查看
+// 查看会计入访问次数，链接预览机器人的 GET 请求不会消耗阅后即焚的次数
+func SharePageHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if c.Method() != fiber.MethodPost {
+		link, err := service.ShareService.GetByCode(vars.Database, c.Params("code"))
+		if err != nil {
+			if errors.Is(err, service.ErrShareNotFound) {
+				return fiber.ErrNotFound
+			}
+			return err
+		}
+		return renderHTML(c, "share.html", fiber.Map{
+			"Title":        "分享",
+			"Locked":       true,
+			"NeedPassword": link.HasPassword,
+		})
+	}
+
+	// 密码只从 POST 表单读取，避免出现在访问日志和 Referer 中
+	var form struct {
+		Password string `form:"password"`
+	}
+	if err := c.BodyParser(&form); err != nil {
+		return fiber.ErrBadRequest
+	}
+	link, err := service.ShareService.Access(vars.Database, c.Params("code"), form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrShareNotFound):
+			return fiber.ErrNotFound
+		case errors.Is(err, service.ErrSharePassword), errors.Is(err, service.ErrShareWrongPassword):
+			data := fiber.Map{"Title": "分享", "Locked": true, "NeedPassword": true}
+			if form.Password != "" {
+				data["Error"] = "密码错误"
+			}
+			return renderHTML(c, "share.html", data)
+		}
+		return err
+	}
+
+	images, err := service.ShareService.Images(vars.Database, link)
+	if err != nil {
+		return err
+	}
+	baseUrl := siteBaseURL(c)
+	for _, image := range images {
+		image.URL = baseUrl + image.URL
+	}
+	return renderHTML(c, "share.html", fiber.Map{
+		"Title":  "分享",
+		"Share":  link,
+		"Images": images,
+	})
+}
+
+// ShareImageHandler 分享内的图片，需要查看分享时生成的签名，每次请求都重新检查分享是否有效
+func ShareImageHandler(c *fiber.Ctx) error {
+	ip := clientIP(c)
+	if allowed, err := checkRequestLimit(c, ip); !allowed {
+		return err
+	}
+	fileName := c.Params("filename")
+	token := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if !service.SignService.Verify("share:"+token, c.Query("e"), c.Query("s")) {
+		return fiber.ErrForbidden
+	}
+	image, err := service.ShareService.GetImage(vars.Database, c.Params("code"), token)
+	if err != nil {
+		if errors.Is(err, service.ErrShareNotFound) {
+			return fiber.ErrNotFound
+		}
+		return err
+	}
+	if err = ensureImageCached(c, image); err != nil {
+		return err
+	}
+	service.CacheService.Touch(image.LocalPath)
+	expire, _ := strconv.ParseInt(c.Query("e"), 10, 64)
+	c.Set(fiber.HeaderCacheControl, "private, max-age="+strconv.FormatInt(expire-time.Now().Unix(), 10))
+	etag := `"` + image.Hash + strings.ReplaceAll(image.ExtName, ".", "-") + `"`
+	sent, err := sendImageFile(c, image.LocalPath, etag, time.Unix(image.CreateTime, 0), func(r io.Reader) io.Reader {
+		return service.DeliveryLimitService.ThrottleReader(r, ip, func() {
+			recordLimitHit("bandwidth")
+		})
+	})
+	if err != nil {
+		return err
+	}
+	service.ImageCounterService.Incr(image.ID, sent)
+	return nil
+}
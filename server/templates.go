@@ -0,0 +1,47 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"formatTime": func(ts int64) string {
+		return time.Unix(ts, 0).Format("2006-01-02 15:04")
+	},
+	"formatSize": func(size int64) string {
+		units := []string{"B", "KB", "MB", "GB"}
+		value, i := float64(size), 0
+		for value >= 1024 && i < len(units)-1 {
+			value /= 1024
+			i++
+		}
+		if i == 0 {
+			return fmt.Sprintf("%d B", size)
+		}
+		return fmt.Sprintf("%.1f %s", value, units[i])
+	},
+}).ParseFS(templateFS, "templates/*.html"))
+
+// renderHTML 渲染服务端页面模板
+func renderHTML(c *fiber.Ctx, name string, data fiber.Map) error {
+	data["SiteName"] = vars.SiteName
+	c.Type("html", "utf-8")
+	return templates.ExecuteTemplate(c.Response().BodyWriter(), name, data)
+}
+
+// siteBaseURL 返回对外访问的站点地址，未配置时使用请求地址
+func siteBaseURL(c *fiber.Ctx) string {
+	if vars.BaseURL != "" {
+		return vars.BaseURL
+	}
+	return c.BaseURL()
+}
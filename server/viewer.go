@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"github.com/zjyl1994/momoka/service"
+)
+
+// pageMeta 页面的 OpenGraph/Twitter 卡片信息
+type pageMeta struct {
+	Title       string
+	Description string
+	PageURL     string
+	ImageURL    string
+	ImageType   string
+	Width       int
+	Height      int
+	OEmbedURL   string
+	RSSURL      string
+	AtomURL     string
+	NoIndex     bool
+}
+
+// ViewerPageHandler 单张图片的公开查看页
+func ViewerPageHandler(c *fiber.Ctx) error {
+	imageHashId := c.Params("hashid")
+	image, err := loadImageByHashId(imageHashId)
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		return fiber.ErrNotFound
+	}
+
+	baseUrl := siteBaseURL(c)
+	pageURL := baseUrl + "/v/" + imageHashId
+	imageURL := baseUrl + image.URL
+	// 私有图片需要有效签名或管理员令牌，页面和图片地址沿用同一签名
+	if image.Private {
+		if service.SignService.Verify(imageHashId, c.Query("e"), c.Query("s")) {
+			query := "?" + url.Values{"e": {c.Query("e")}, "s": {c.Query("s")}}.Encode()
+			pageURL += query
+			imageURL += query
+		} else if isAdminRequest(c) {
+			imageURL = baseUrl + service.ImageService.SignedURL(image, time.Now().Unix()+common.SHARE_IMAGE_URL_EXPIRE)
+		} else {
+			return fiber.ErrNotFound
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+	}
+
+	// 早期上传的图片没有记录尺寸，从缓存文件读取后写回
+	if image.Width == 0 && ensureImageCached(c, image) == nil {
+		service.ImageService.FillSize(vars.Database, image)
+	}
+	description := image.Remark
+	if description == "" && image.Width > 0 {
+		description = strconv.Itoa(image.Width) + " × " + strconv.Itoa(image.Height)
+	}
+	meta := &pageMeta{
+		Title:       image.Name,
+		Description: description,
+		PageURL:     pageURL,
+		ImageURL:    imageURL,
+		ImageType:   image.ContentType,
+		Width:       max(image.Width, 0),
+		Height:      max(image.Height, 0),
+		OEmbedURL:   baseUrl + "/oembed?" + url.Values{"url": {pageURL}, "format": {"json"}}.Encode(),
+		NoIndex:     image.Private,
+	}
+	// 复制按钮使用设置中的链接格式
+	image.URL = imageURL
+	service.LinkFormatService.Fill(image, baseUrl)
+	return renderHTML(c, "viewer.html", fiber.Map{
+		"Title":    image.Name,
+		"Meta":     meta,
+		"Image":    image,
+		"ImageURL": imageURL,
+		"Links":    append([]common.Link{{Name: "page", Value: pageURL}}, image.Links...),
+	})
+}
+
+// OEmbedHandler oEmbed 接口，支持查看页和图片地址，私有图片需要地址中带有有效签名
+func OEmbedHandler(c *fiber.Ctx) error {
+	if format := c.Query("format", "json"); format != "json" {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
+			"error": "仅支持 JSON 格式",
+		})
+	}
+	target, err := url.Parse(c.Query("url"))
+	if err != nil || target.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "无效的地址",
+		})
+	}
+	var imageHashId string
+	switch dir, file := path.Split(target.Path); dir {
+	case "/v/":
+		imageHashId = file
+	case "/i/":
+		imageHashId = strings.TrimSuffix(file, path.Ext(file))
+	}
+	image, err := loadImageByHashId(imageHashId)
+	if err != nil {
+		return err
+	}
+	query := target.Query()
+	if image == nil || (image.Private && !service.SignService.Verify(imageHashId, query.Get("e"), query.Get("s"))) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "图片不存在",
+		})
+	}
+
+	baseUrl := siteBaseURL(c)
+	imageURL := baseUrl + image.URL
+	if image.Private {
+		imageURL += "?" + url.Values{"e": {query.Get("e")}, "s": {query.Get("s")}}.Encode()
+	}
+	if image.Width == 0 && ensureImageCached(c, image) == nil {
+		service.ImageService.FillSize(vars.Database, image)
+	}
+	result := fiber.Map{
+		"version":       "1.0",
+		"type":          "link",
+		"title":         image.Name,
+		"provider_name": vars.SiteName,
+		"provider_url":  baseUrl,
+	}
+	// photo 类型必须带有尺寸，尺寸未知时退化为 link 类型
+	if image.Width > 0 && image.Height > 0 {
+		width, height := utils.FitSize(image.Width, image.Height, c.QueryInt("maxwidth"), c.QueryInt("maxheight"))
+		result["type"] = "photo"
+		result["url"] = imageURL
+		result["width"] = width
+		result["height"] = height
+	}
+	return c.JSON(result)
+}
+
+// loadImageByHashId 按图片 hashid 加载元数据，无法解析或不存在时返回 nil
+func loadImageByHashId(imageHashId string) (*common.Image, error) {
+	imageId, err := vars.HashID.DecodeInt64WithError(imageHashId)
+	if err != nil || len(imageId) != 2 || imageId[0] != common.ENTITY_TYPE_FILE {
+		return nil, nil
+	}
+	return service.ImageService.CachedGet(vars.Database, imageId[1])
+}
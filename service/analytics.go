@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var AnalyticsDimensions = []string{
+	common.ANALYTICS_DIMENSION_REFERER,
+	common.ANALYTICS_DIMENSION_CLIENT,
+	common.ANALYTICS_DIMENSION_FORMAT,
+	common.ANALYTICS_DIMENSION_COUNTRY,
+}
+
+type analyticsKey struct {
+	date      string
+	dimension string
+	value     string
+}
+
+type analyticsService struct {
+	lock    sync.Mutex
+	pending map[analyticsKey]*common.TrafficStat
+
+	refererDate string              // referers 对应的日期
+	referers    map[string]struct{} // 当天已记录的来源域名
+}
+
+var AnalyticsService = &analyticsService{}
+
+// Record 记录一次图片访问的来源信息，format 为实际发送的图片格式
+func (s *analyticsService) Record(referer, userAgent, format, ip string, size int64) {
+	values := map[string]string{
+		common.ANALYTICS_DIMENSION_REFERER: s.refererDomain(referer),
+		common.ANALYTICS_DIMENSION_CLIENT:  utils.UAFamily(userAgent),
+		common.ANALYTICS_DIMENSION_FORMAT:  format,
+	}
+	if vars.GeoIPReader != nil {
+		values[common.ANALYTICS_DIMENSION_COUNTRY] = s.country(ip)
+	}
+
+	date := time.Now().Format(common.STAT_DATE_FORMAT)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[analyticsKey]*common.TrafficStat)
+	}
+	values[common.ANALYTICS_DIMENSION_REFERER] = s.limitRefererLocked(date, values[common.ANALYTICS_DIMENSION_REFERER])
+	for dimension, value := range values {
+		key := analyticsKey{date, dimension, value}
+		stat, ok := s.pending[key]
+		if !ok {
+			stat = &common.TrafficStat{Date: date, Dimension: dimension, Value: value}
+			s.pending[key] = stat
+		}
+		stat.Hits++
+		stat.Bandwidth += size
+	}
+}
+
+// Save 将内存中的来源统计累加到数据库，并清理超出保留期的数据
+func (s *analyticsService) Save(ctx context.Context) {
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.lock.Unlock()
+
+	if len(pending) > 0 {
+		stats := make([]*common.TrafficStat, 0, len(pending))
+		for _, stat := range pending {
+			stats = append(stats, stat)
+		}
+		err := vars.Database.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "date"}, {Name: "dimension"}, {Name: "value"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"hits":      gorm.Expr("hits + excluded.hits"),
+				"bandwidth": gorm.Expr("bandwidth + excluded.bandwidth"),
+			}),
+		}).CreateInBatches(stats, 100).Error
+		if err != nil {
+			logrus.Errorln("Save traffic stats failed", err)
+			s.lock.Lock()
+			for key, stat := range pending {
+				s.mergeLocked(key, stat)
+			}
+			s.lock.Unlock()
+		}
+	}
+
+	if vars.StatRetentionDay > 0 {
+		before := time.Now().AddDate(0, 0, -vars.StatRetentionDay).Format(common.STAT_DATE_FORMAT)
+		if err := vars.Database.Delete(&common.TrafficStat{}, "date < ?", before).Error; err != nil {
+			logrus.Errorln("Clean traffic stats failed", err)
+		}
+	}
+}
+
+func (s *analyticsService) mergeLocked(key analyticsKey, stat *common.TrafficStat) {
+	if s.pending == nil {
+		s.pending = make(map[analyticsKey]*common.TrafficStat)
+	}
+	current, ok := s.pending[key]
+	if !ok {
+		s.pending[key] = stat
+		return
+	}
+	current.Hits += stat.Hits
+	current.Bandwidth += stat.Bandwidth
+}
+
+// Top 查询 [start, end] 日期范围内某个维度按访问次数排序的前 limit 项
+func (s *analyticsService) Top(db *gorm.DB, dimension string, start, end time.Time, limit int) ([]*common.AnalyticsItem, error) {
+	startDate, endDate := start.Format(common.STAT_DATE_FORMAT), end.Format(common.STAT_DATE_FORMAT)
+	var items []*common.AnalyticsItem
+	err := db.Model(&common.TrafficStat{}).
+		Select("value, SUM(hits) AS hits, SUM(bandwidth) AS bandwidth").
+		Where("dimension = ? AND date >= ? AND date <= ?", dimension, startDate, endDate).
+		Group("value").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 合并尚未刷入数据库的部分后再排序截取
+	itemMap := make(map[string]*common.AnalyticsItem, len(items))
+	for _, item := range items {
+		itemMap[item.Value] = item
+	}
+	s.lock.Lock()
+	for key, stat := range s.pending {
+		if key.dimension != dimension || key.date < startDate || key.date > endDate {
+			continue
+		}
+		item, ok := itemMap[key.value]
+		if !ok {
+			item = &common.AnalyticsItem{Value: key.value}
+			itemMap[key.value] = item
+			items = append(items, item)
+		}
+		item.Hits += stat.Hits
+		item.Bandwidth += stat.Bandwidth
+	}
+	s.lock.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Hits != items[j].Hits {
+			return items[i].Hits > items[j].Hits
+		}
+		return items[i].Value < items[j].Value
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// limitRefererLocked 限制每天记录的来源域名数量，超出上限的新域名合并为 (other)
+func (s *analyticsService) limitRefererLocked(date, domain string) string {
+	if domain == common.ANALYTICS_VALUE_DIRECT || domain == common.ANALYTICS_VALUE_UNKNOWN {
+		return domain
+	}
+	if s.refererDate != date {
+		// 换日或重启后从数据库加载当天已有的域名，保证上限对整天有效
+		var known []string
+		err := vars.Database.Model(&common.TrafficStat{}).
+			Where("date = ? AND dimension = ?", date, common.ANALYTICS_DIMENSION_REFERER).
+			Pluck("value", &known).Error
+		if err != nil {
+			logrus.Errorln("Load referer stats failed", err)
+		}
+		s.refererDate = date
+		s.referers = make(map[string]struct{}, len(known))
+		for _, value := range known {
+			s.referers[value] = struct{}{}
+		}
+	}
+	if _, ok := s.referers[domain]; ok {
+		return domain
+	}
+	if len(s.referers) >= common.ANALYTICS_MAX_REFERERS {
+		return common.ANALYTICS_VALUE_OTHER
+	}
+	s.referers[domain] = struct{}{}
+	return domain
+}
+
+// refererDomain 提取来源域名，没有来源时记为直接访问
+func (s *analyticsService) refererDomain(referer string) string {
+	if referer == "" {
+		return common.ANALYTICS_VALUE_DIRECT
+	}
+	refURL, err := url.Parse(referer)
+	if err != nil || refURL.Hostname() == "" {
+		return common.ANALYTICS_VALUE_UNKNOWN
+	}
+	return strings.ToLower(refURL.Hostname())
+}
+
+// country 从 GeoLite 数据库查询 IP 所属国家代码
+func (s *analyticsService) country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return common.ANALYTICS_VALUE_UNKNOWN
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := vars.GeoIPReader.Lookup(addr, &record); err != nil || record.Country.ISOCode == "" {
+		return common.ANALYTICS_VALUE_UNKNOWN
+	}
+	return record.Country.ISOCode
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAPITokenInvalid     = errors.New("api token is invalid or expired")
+	ErrAPITokenIPDenied    = errors.New("api token is not allowed from this address")
+	ErrAPITokenForbidden   = errors.New("api token does not have the required scope")
+	ErrAPITokenNotInHeader = errors.New("api token must be sent in the Authorization header")
+)
+
+type apiTokenService struct{}
+
+var APITokenService = &apiTokenService{}
+
+// Add 生成新令牌并保存哈希，明文只在返回的 Token 字段中出现一次
+func (s *apiTokenService) Add(db *gorm.DB, token *common.APIToken) error {
+	plain := common.API_TOKEN_PREFIX + rand.Text()
+	token.TokenHash = s.hash(plain)
+	token.Prefix = plain[:len(common.API_TOKEN_PREFIX)+6]
+	token.Scopes = strings.Join(token.ScopeList, ",")
+	token.AllowIPs = strings.Join(token.AllowIPList, ",")
+	if err := db.Create(token).Error; err != nil {
+		return err
+	}
+	token.Token = plain
+	return nil
+}
+
+func (s *apiTokenService) List(db *gorm.DB) ([]*common.APIToken, error) {
+	var tokens []*common.APIToken
+	if err := db.Order("create_time DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		s.FillModel(token)
+	}
+	return tokens, nil
+}
+
+func (s *apiTokenService) Revoke(db *gorm.DB, id int64) error {
+	return db.Model(&common.APIToken{}).Where("id = ?", id).Update("revoked", true).Error
+}
+
+func (s *apiTokenService) Delete(db *gorm.DB, id int64) error {
+	return db.Delete(&common.APIToken{}, id).Error
+}
+
+// Authenticate 校验令牌明文和来源地址，成功时记录最后使用时间
+func (s *apiTokenService) Authenticate(db *gorm.DB, plain, ip string) (*common.APIToken, error) {
+	if !strings.HasPrefix(plain, common.API_TOKEN_PREFIX) {
+		return nil, ErrAPITokenInvalid
+	}
+	var token common.APIToken
+	if err := db.Where("token_hash = ?", s.hash(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPITokenInvalid
+		}
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if token.Revoked || (token.ExpireTime > 0 && now > token.ExpireTime) {
+		return nil, ErrAPITokenInvalid
+	}
+	s.FillModel(&token)
+	if !token.AllowIP(ip) {
+		return nil, ErrAPITokenIPDenied
+	}
+	if now-token.LastUsedTime >= common.API_TOKEN_TOUCH_INTERVAL || token.LastUsedIP != ip {
+		token.LastUsedTime = now
+		token.LastUsedIP = ip
+		err := db.Model(&common.APIToken{}).Where("id = ?", token.ID).UpdateColumns(map[string]any{
+			"last_used_time": now,
+			"last_used_ip":   ip,
+		}).Error
+		if err != nil {
+			logrus.Warnln("update api token last used time failed", err)
+		}
+	}
+	return &token, nil
+}
+
+func (s *apiTokenService) FillModel(m *common.APIToken) {
+	m.ScopeList = lo.Compact(strings.Split(m.Scopes, ","))
+	m.AllowIPList = lo.Compact(strings.Split(m.AllowIPs, ","))
+}
+
+func (s *apiTokenService) hash(plain string) string {
+	return hex.EncodeToString(utils.SHA256Hash([]byte(plain)))
+}
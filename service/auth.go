@@ -0,0 +1,21 @@
+package service
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+type authService struct{}
+
+var AuthService = &authService{}
+
+// ValidateJWT 校验管理员登录令牌
+func (s *authService) ValidateJWT(tokenStr string) bool {
+	if tokenStr == "" {
+		return false
+	}
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
+		return []byte(vars.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	return err == nil && token.Valid
+}
@@ -30,15 +30,20 @@ func (s *backupService) GenerateMetadata() ([]byte, error) {
 	if err := vars.Database.Find(&imageTags).Error; err != nil {
 		return nil, err
 	}
+	var imageVariants []common.ImageVariant
+	if err := vars.Database.Find(&imageVariants).Error; err != nil {
+		return nil, err
+	}
 	var settings []common.Setting
 	if err := vars.Database.Find(&settings).Error; err != nil {
 		return nil, err
 	}
 	result := common.BackupFormat{
-		Version:   common.BACKUP_FILE_VERSION,
-		Images:    images,
-		ImageTags: imageTags,
-		Settings:  settings,
+		Version:       common.BACKUP_FILE_VERSION,
+		Images:        images,
+		ImageTags:     imageTags,
+		ImageVariants: imageVariants,
+		Settings:      settings,
 	}
 	data, err := json.Marshal(result)
 	if err != nil {
@@ -59,6 +64,7 @@ func (s *backupService) RestoreMetadata(data []byte) error {
 	if result.Version > common.BACKUP_FILE_VERSION {
 		return errors.New("backup file version is not supported")
 	}
+	defer ImageService.ClearMissCache()
 	return vars.Database.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&common.Image{}).Error; err != nil {
 			return err
@@ -74,6 +80,13 @@ func (s *backupService) RestoreMetadata(data []byte) error {
 			return err
 		}
 
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&common.ImageVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(&result.ImageVariants, 100).Error; err != nil {
+			return err
+		}
+
 		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&common.Setting{}).Error; err != nil {
 			return err
 		}
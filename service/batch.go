@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+)
+
+var (
+	ErrBatchTooManyFiles = errors.New("too many files in one batch")
+	ErrBatchTooLarge     = errors.New("extracted size exceeds limit")
+)
+
+// batchUpload 一次批量上传的处理状态
+type batchUpload struct {
+	opts      common.BatchUploadOptions
+	results   []common.BatchUploadResult
+	extracted int64
+}
+
+// IngestBatch 批量入库上传的文件，ZIP/TAR 压缩包会解出其中的文件逐个入库
+// 单个文件失败不影响其它文件，每个文件的结果按处理顺序返回
+func (s *uploadService) IngestBatch(files []*multipart.FileHeader, opts common.BatchUploadOptions) []common.BatchUploadResult {
+	b := &batchUpload{opts: opts}
+	for _, fileHeader := range files {
+		err := b.addFile(fileHeader)
+		if err == nil {
+			continue
+		}
+		// 达到整批的数量或解压大小上限后不再处理剩余的文件
+		if errors.Is(err, ErrBatchTooManyFiles) || errors.Is(err, ErrBatchTooLarge) {
+			b.add(fileHeader.Filename, nil, false, fmt.Errorf("%w, remaining entries skipped", err))
+			break
+		}
+		logrus.Warnf("Failed to process batch file %s: %v", fileHeader.Filename, err)
+		b.add(fileHeader.Filename, nil, false, err)
+	}
+	return b.results
+}
+
+func (b *batchUpload) addFile(fileHeader *multipart.FileHeader) error {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if kind := utils.ArchiveType(head[:n]); kind != "" {
+		return utils.WalkArchive(file, fileHeader.Size, kind, func(name string, r io.Reader) error {
+			fullName := fileHeader.Filename + "/" + name
+			if len(b.results) >= common.BATCH_MAX_FILES {
+				return ErrBatchTooManyFiles
+			}
+			data, err := io.ReadAll(io.LimitReader(r, common.MAX_IMAGE_SIZE+1))
+			if b.extracted += int64(len(data)); b.extracted > common.BATCH_MAX_EXTRACT_SIZE {
+				return ErrBatchTooLarge
+			}
+			// 单个条目损坏或无法解压时只拒绝该条目
+			if err != nil {
+				logrus.Warnf("Failed to read archive entry %s: %v", fullName, err)
+				b.add(fullName, nil, false, err)
+				return nil
+			}
+			if len(data) > common.MAX_IMAGE_SIZE {
+				b.add(fullName, nil, false, ErrFileTooLarge)
+				return nil
+			}
+			var pathTags []string
+			if b.opts.PathTags {
+				pathTags = archivePathTags(name)
+			}
+			b.ingest(fullName, data, "", pathTags)
+			return nil
+		})
+	}
+
+	if len(b.results) >= common.BATCH_MAX_FILES {
+		return ErrBatchTooManyFiles
+	}
+	if fileHeader.Size > common.MAX_IMAGE_SIZE {
+		return ErrFileTooLarge
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, fileHeader.Size))
+	if err != nil {
+		return err
+	}
+	b.ingest(fileHeader.Filename, data, fileHeader.Header.Get("Content-Type"), nil)
+	return nil
+}
+
+// ingest 入库单个文件，优先按内容判断类型，无法识别时使用客户端声明的类型
+func (b *batchUpload) ingest(name string, data []byte, contentType string, pathTags []string) {
+	if detected := utils.DetectImageType(data); detected != "" {
+		contentType = detected
+	}
+	filename := path.Base(name)
+	if filepath.Ext(filename) == "" {
+		filename += utils.ImageExtName(contentType)
+	}
+	tags := b.opts.Tags
+	if len(pathTags) > 0 {
+		tags = lo.Uniq(append(slices.Clone(b.opts.Tags), pathTags...))
+	}
+	image, created, err := UploadService.Ingest(&common.UploadFile{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+		Remark:      b.opts.Remark,
+		Tags:        tags,
+		Private:     b.opts.Private,
+	})
+	if err != nil && !errors.Is(err, ErrNotImage) && !errors.Is(err, ErrFileTooLarge) {
+		logrus.Errorf("Failed to save batch image %s: %v", name, err)
+	}
+	b.add(name, image, created, err)
+}
+
+func (b *batchUpload) add(name string, image *common.Image, created bool, err error) {
+	result := common.BatchUploadResult{Name: name, Image: image}
+	switch {
+	case err != nil:
+		result.Status = common.BATCH_STATUS_REJECTED
+		result.Reason = err.Error()
+	case created:
+		result.Status = common.BATCH_STATUS_CREATED
+	default:
+		result.Status = common.BATCH_STATUS_DEDUPLICATED
+	}
+	b.results = append(b.results, result)
+}
+
+// archivePathTags 压缩包内文件所在的各级目录名，如 "2024/截图/a.png" 得到 2024 和 截图
+func archivePathTags(name string) []string {
+	var tags []string
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if dir = strings.TrimSpace(dir); dir != "" && dir != "." && dir != ".." {
+			tags = append(tags, dir)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+)
+
+type cacheService struct {
+	memory *utils.MemoryCache
+
+	touchLock sync.Mutex
+	touched   map[string]time.Time
+
+	diskFiles atomic.Int64 // 磁盘缓存的文件数和总大小，由 RefreshDiskStats 定期统计
+	diskSize  atomic.Int64
+}
+
+var CacheService = &cacheService{}
+
+// Init 设置内存缓存的字节预算，0 为不启用内存缓存
+func (s *cacheService) Init(budget int64) {
+	if budget > 0 {
+		s.memory = utils.NewMemoryCache(budget)
+	}
+}
+
+// Exists 检查文件是否已缓存在内存或本地磁盘中
+func (s *cacheService) Exists(path string) bool {
+	if s.memory != nil && s.memory.Has(path) {
+		return true
+	}
+	return utils.FileExists(path)
+}
+
+// Load 读取内存中缓存的文件内容
+func (s *cacheService) Load(path string) ([]byte, bool) {
+	if s.memory == nil {
+		return nil, false
+	}
+	return s.memory.Get(path)
+}
+
+// Admit 判断文件是否适合放入内存缓存
+func (s *cacheService) Admit(size int64) bool {
+	return s.memory != nil && size <= common.MEMORY_CACHE_MAX_OBJECT_SIZE
+}
+
+// Store 写入内存缓存
+func (s *cacheService) Store(path string, data []byte) {
+	if s.memory != nil {
+		s.memory.Set(path, data)
+	}
+}
+
+// Evict 从内存缓存中移除文件，文件被删除或重新生成时调用
+func (s *cacheService) Evict(path string) {
+	if s.memory != nil {
+		s.memory.Del(path)
+	}
+}
+
+// MemoryStats 返回内存缓存的项数和占用字节数
+func (s *cacheService) MemoryStats() (int, int64) {
+	if s.memory == nil {
+		return 0, 0
+	}
+	return s.memory.Stats()
+}
+
+// RefreshDiskStats 遍历缓存目录统计文件数和总大小，供指标读取，避免每次抓取都遍历目录
+func (s *cacheService) RefreshDiskStats(ctx context.Context) {
+	fileCount, totalSize, err := utils.GetFolderForDashboard(utils.DataPath("cache"))
+	if err != nil {
+		logrus.Errorln("get cache size failed", err)
+		return
+	}
+	s.diskFiles.Store(fileCount)
+	s.diskSize.Store(totalSize)
+}
+
+// DiskStats 返回最近一次统计的磁盘缓存文件数和总大小
+func (s *cacheService) DiskStats() (int64, int64) {
+	return s.diskFiles.Load(), s.diskSize.Load()
+}
+
+// Touch 记录文件被访问，访问时间由 FlushTouched 批量写回磁盘
+func (s *cacheService) Touch(path string) {
+	s.touchLock.Lock()
+	defer s.touchLock.Unlock()
+	if s.touched == nil {
+		s.touched = make(map[string]time.Time)
+	}
+	s.touched[path] = time.Now()
+}
+
+// FlushTouched 将记录的访问时间写回文件，供缓存清理按时间淘汰
+func (s *cacheService) FlushTouched(ctx context.Context) {
+	s.touchLock.Lock()
+	touched := s.touched
+	s.touched = nil
+	s.touchLock.Unlock()
+
+	for path, t := range touched {
+		if err := os.Chtimes(path, t, t); err != nil && !os.IsNotExist(err) {
+			logrus.Errorln("update cache access time failed", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+type cachePolicyService struct{}
+
+var CachePolicyService = &cachePolicyService{}
+
+// Match 返回第一条匹配的缓存规则，没有匹配时返回 nil
+// loadTags 只在规则需要按标签匹配时调用，避免每次请求都查询标签
+func (s *cachePolicyService) Match(image *common.Image, contentType string, loadTags func() ([]string, error)) (*common.CacheRule, error) {
+	var tags []string
+	var tagsLoaded bool
+	for i := range vars.CachePolicy.Rules {
+		rule := &vars.CachePolicy.Rules[i]
+		if rule.Visibility == common.IMAGE_VISIBILITY_PUBLIC && image.Private ||
+			rule.Visibility == common.IMAGE_VISIBILITY_PRIVATE && !image.Private {
+			continue
+		}
+		if len(rule.ContentTypes) > 0 && !slices.ContainsFunc(rule.ContentTypes, func(pattern string) bool {
+			ok, _ := path.Match(pattern, contentType)
+			return ok
+		}) {
+			continue
+		}
+		if len(rule.Tags) > 0 {
+			if !tagsLoaded {
+				var err error
+				if tags, err = loadTags(); err != nil {
+					return nil, err
+				}
+				tagsLoaded = true
+			}
+			if !slices.ContainsFunc(rule.Tags, func(tag string) bool {
+				return slices.Contains(tags, tag)
+			}) {
+				continue
+			}
+		}
+		return rule, nil
+	}
+	return nil, nil
+}
+
+// CacheControl 按规则生成公开图片的 Cache-Control
+func (s *cachePolicyService) CacheControl(rule *common.CacheRule) string {
+	if rule.NoStore {
+		return "no-store"
+	}
+	directives := []string{"public", "max-age=" + strconv.Itoa(rule.MaxAge)}
+	if rule.SMaxAge > 0 {
+		directives = append(directives, "s-maxage="+strconv.Itoa(rule.SMaxAge))
+	}
+	if rule.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+strconv.Itoa(rule.StaleWhileRevalidate))
+	}
+	if rule.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidGranularity = errors.New("invalid granularity")
+
+type dailyStatService struct {
+	lock    sync.Mutex
+	pending map[string]*common.DailyStat
+}
+
+var DailyStatService = &dailyStatService{}
+
+// AddHit 记录一次图片访问
+func (s *dailyStatService) AddHit(size int64) {
+	s.add(func(stat *common.DailyStat) {
+		stat.Hits++
+		stat.Bandwidth += size
+	})
+}
+
+// AddUpload 记录一张新入库的图片
+func (s *dailyStatService) AddUpload(size int64) {
+	s.add(func(stat *common.DailyStat) {
+		stat.Uploads++
+		stat.StorageGrowth += size
+	})
+}
+
+// AddDelete 记录删除图片释放的空间
+func (s *dailyStatService) AddDelete(size int64) {
+	s.add(func(stat *common.DailyStat) {
+		stat.StorageGrowth -= size
+	})
+}
+
+// AddConversion 记录一次格式转换
+func (s *dailyStatService) AddConversion() {
+	s.add(func(stat *common.DailyStat) {
+		stat.Conversions++
+	})
+}
+
+func (s *dailyStatService) add(fn func(stat *common.DailyStat)) {
+	date := time.Now().Format(common.STAT_DATE_FORMAT)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[string]*common.DailyStat)
+	}
+	stat, ok := s.pending[date]
+	if !ok {
+		stat = &common.DailyStat{Date: date}
+		s.pending[date] = stat
+	}
+	fn(stat)
+}
+
+// Save 将内存中的统计累加到数据库，并清理超出保留期的数据
+func (s *dailyStatService) Save(ctx context.Context) {
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.lock.Unlock()
+
+	if len(pending) > 0 {
+		stats := make([]*common.DailyStat, 0, len(pending))
+		for _, stat := range pending {
+			stats = append(stats, stat)
+		}
+		err := vars.Database.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "date"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"hits":           gorm.Expr("hits + excluded.hits"),
+				"bandwidth":      gorm.Expr("bandwidth + excluded.bandwidth"),
+				"uploads":        gorm.Expr("uploads + excluded.uploads"),
+				"storage_growth": gorm.Expr("storage_growth + excluded.storage_growth"),
+				"conversions":    gorm.Expr("conversions + excluded.conversions"),
+			}),
+		}).Create(stats).Error
+		if err != nil {
+			logrus.Errorln("Save daily stats failed", err)
+			s.lock.Lock()
+			for _, stat := range stats {
+				s.mergeLocked(stat)
+			}
+			s.lock.Unlock()
+		}
+	}
+
+	if vars.StatRetentionDay > 0 {
+		before := time.Now().AddDate(0, 0, -vars.StatRetentionDay).Format(common.STAT_DATE_FORMAT)
+		if err := vars.Database.Delete(&common.DailyStat{}, "date < ?", before).Error; err != nil {
+			logrus.Errorln("Clean daily stats failed", err)
+		}
+	}
+}
+
+func (s *dailyStatService) mergeLocked(stat *common.DailyStat) {
+	if s.pending == nil {
+		s.pending = make(map[string]*common.DailyStat)
+	}
+	current, ok := s.pending[stat.Date]
+	if !ok {
+		s.pending[stat.Date] = stat
+		return
+	}
+	current.Hits += stat.Hits
+	current.Bandwidth += stat.Bandwidth
+	current.Uploads += stat.Uploads
+	current.StorageGrowth += stat.StorageGrowth
+	current.Conversions += stat.Conversions
+}
+
+// History 查询 [start, end] 日期范围内的统计，按粒度汇总，没有数据的时间段补零
+func (s *dailyStatService) History(db *gorm.DB, start, end time.Time, granularity string) ([]*common.StatPoint, error) {
+	periodStart, ok := statPeriodFunc(granularity)
+	if !ok {
+		return nil, ErrInvalidGranularity
+	}
+	startDate, endDate := start.Format(common.STAT_DATE_FORMAT), end.Format(common.STAT_DATE_FORMAT)
+	var stats []*common.DailyStat
+	if err := db.Where("date >= ? AND date <= ?", startDate, endDate).Order("date").Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	// 合并尚未刷入数据库的部分
+	s.lock.Lock()
+	for date, stat := range s.pending {
+		if date >= startDate && date <= endDate {
+			copied := *stat
+			stats = append(stats, &copied)
+		}
+	}
+	s.lock.Unlock()
+
+	var points []*common.StatPoint
+	pointMap := make(map[string]*common.StatPoint)
+	for day := periodStart(start); !day.After(end); day = nextStatPeriod(day, granularity) {
+		point := &common.StatPoint{Period: day.Format(common.STAT_DATE_FORMAT)}
+		points = append(points, point)
+		pointMap[point.Period] = point
+	}
+	for _, stat := range stats {
+		date, err := time.ParseInLocation(common.STAT_DATE_FORMAT, stat.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		point, ok := pointMap[periodStart(date).Format(common.STAT_DATE_FORMAT)]
+		if !ok {
+			continue
+		}
+		point.Hits += stat.Hits
+		point.Bandwidth += stat.Bandwidth
+		point.Uploads += stat.Uploads
+		point.StorageGrowth += stat.StorageGrowth
+		point.Conversions += stat.Conversions
+	}
+	return points, nil
+}
+
+// statPeriodFunc 返回计算日期所在时间段起始日的函数，周从周一开始
+func statPeriodFunc(granularity string) (func(time.Time) time.Time, bool) {
+	switch granularity {
+	case common.STAT_GRANULARITY_DAY:
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		}, true
+	case common.STAT_GRANULARITY_WEEK:
+		return func(t time.Time) time.Time {
+			offset := (int(t.Weekday()) + 6) % 7
+			return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+		}, true
+	case common.STAT_GRANULARITY_MONTH:
+		return func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		}, true
+	}
+	return nil, false
+}
+
+func nextStatPeriod(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case common.STAT_GRANULARITY_WEEK:
+		return t.AddDate(0, 0, 7)
+	case common.STAT_GRANULARITY_MONTH:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// ParseStatRetentionDay 解析每日统计保留天数设置，0 为永久保留，无效时使用默认值
+func ParseStatRetentionDay(s string) int {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return common.DEFAULT_STAT_RETENTION_DAY
+	}
+	return v
+}
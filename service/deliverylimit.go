@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+var ErrDownloadBusy = errors.New("too many concurrent downloads")
+
+type ipLimiter struct {
+	requests  *utils.TokenBucket
+	bandwidth *utils.TokenBucket
+	lastSeen  time.Time
+}
+
+type deliveryLimitService struct {
+	lock      sync.Mutex
+	allowNets []*net.IPNet
+	ips       map[string]*ipLimiter
+	global    *utils.TokenBucket
+	downloads chan struct{}
+}
+
+var DeliveryLimitService = &deliveryLimitService{}
+
+// Reload 按新的策略重建限流状态
+func (s *deliveryLimitService) Reload() {
+	policy := vars.DeliveryLimit
+	allowNets, err := policy.AllowNets()
+	if err != nil {
+		logrus.Errorln("parse delivery limit allow list failed", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.allowNets = allowNets
+	s.ips = make(map[string]*ipLimiter)
+	s.global = nil
+	if policy.Enabled && policy.GlobalBandwidth > 0 {
+		s.global = utils.NewTokenBucket(float64(policy.GlobalBandwidth), s.bandwidthBurst(policy.GlobalBandwidth))
+	}
+	s.downloads = nil
+	if policy.Enabled && policy.MaxS3Downloads > 0 {
+		s.downloads = make(chan struct{}, policy.MaxS3Downloads)
+	}
+}
+
+func (s *deliveryLimitService) bandwidthBurst(rate int64) float64 {
+	if vars.DeliveryLimit.BandwidthBurst > 0 {
+		return float64(vars.DeliveryLimit.BandwidthBurst)
+	}
+	return float64(rate)
+}
+
+func (s *deliveryLimitService) allowed(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, ipNet := range s.allowNets {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getIPLimiterLocked 获取 IP 对应的限流器，白名单中的 IP 返回 nil
+func (s *deliveryLimitService) getIPLimiterLocked(ip string) *ipLimiter {
+	if s.allowed(ip) {
+		return nil
+	}
+	limiter, ok := s.ips[ip]
+	if !ok {
+		policy := vars.DeliveryLimit
+		limiter = &ipLimiter{}
+		if policy.RequestRate > 0 {
+			burst := float64(policy.RequestBurst)
+			if burst <= 0 {
+				burst = math.Ceil(policy.RequestRate)
+			}
+			limiter.requests = utils.NewTokenBucket(policy.RequestRate, burst)
+		}
+		if policy.IPBandwidth > 0 {
+			limiter.bandwidth = utils.NewTokenBucket(float64(policy.IPBandwidth), s.bandwidthBurst(policy.IPBandwidth))
+		}
+		if s.ips == nil {
+			s.ips = make(map[string]*ipLimiter)
+		}
+		s.ips[ip] = limiter
+	}
+	limiter.lastSeen = time.Now()
+	return limiter
+}
+
+// AllowRequest 检查 IP 的请求频率是否超出限制
+func (s *deliveryLimitService) AllowRequest(ip string) bool {
+	if !vars.DeliveryLimit.Enabled || vars.DeliveryLimit.RequestRate <= 0 {
+		return true
+	}
+	s.lock.Lock()
+	limiter := s.getIPLimiterLocked(ip)
+	s.lock.Unlock()
+	if limiter == nil || limiter.requests == nil {
+		return true
+	}
+	return limiter.requests.Allow()
+}
+
+// ThrottleReader 按 IP 和全局带宽上限限制响应速度，没有限制时原样返回
+func (s *deliveryLimitService) ThrottleReader(r io.Reader, ip string, onThrottle func()) io.Reader {
+	if !vars.DeliveryLimit.Enabled {
+		return r
+	}
+	s.lock.Lock()
+	var buckets []*utils.TokenBucket
+	if limiter := s.getIPLimiterLocked(ip); limiter != nil {
+		if limiter.bandwidth != nil {
+			buckets = append(buckets, limiter.bandwidth)
+		}
+		if s.global != nil {
+			buckets = append(buckets, s.global)
+		}
+	}
+	s.lock.Unlock()
+	if len(buckets) == 0 {
+		return r
+	}
+	return utils.ThrottleReader(r, onThrottle, buckets...)
+}
+
+// AcquireDownload 获取一个 S3 下载名额，最多等待 wait，成功时返回释放函数
+func (s *deliveryLimitService) AcquireDownload(wait time.Duration) (func(), error) {
+	s.lock.Lock()
+	downloads := s.downloads
+	s.lock.Unlock()
+	if downloads == nil {
+		return func() {}, nil
+	}
+	release := func() { <-downloads }
+	select {
+	case downloads <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if wait <= 0 {
+		return nil, ErrDownloadBusy
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case downloads <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrDownloadBusy
+	}
+}
+
+// Cleanup 清理长时间没有请求的 IP 限流器
+func (s *deliveryLimitService) Cleanup(ctx context.Context) {
+	expire := time.Now().Add(-10 * time.Minute)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ip, limiter := range s.ips {
+		if limiter.lastSeen.Before(expire) {
+			delete(s.ips, ip)
+		}
+	}
+}
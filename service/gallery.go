@@ -0,0 +1,109 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+)
+
+type galleryService struct{}
+
+var GalleryService = &galleryService{}
+
+// Find 查找公开的标签，未公开的标签返回 false
+func (s *galleryService) Find(name string) (common.GalleryTag, bool) {
+	for _, tag := range vars.Gallery.Tags {
+		if tag.Name == name {
+			return tag, true
+		}
+	}
+	return common.GalleryTag{}, false
+}
+
+// PageSize 画廊每页图片数
+func (s *galleryService) PageSize() int {
+	if vars.Gallery.PageSize > 0 {
+		return vars.Gallery.PageSize
+	}
+	return common.DEFAULT_GALLERY_PAGE_SIZE
+}
+
+// Tags 列出全部公开标签及其公开图片数量
+func (s *galleryService) Tags(db *gorm.DB) ([]common.GalleryTagCount, error) {
+	tags := vars.Gallery.Tags
+	if len(tags) == 0 {
+		return []common.GalleryTagCount{}, nil
+	}
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	var counts []struct {
+		TagName string
+		Count   int64
+	}
+	err := db.Model(&common.ImageTags{}).
+		Select("image_tags.tag_name, COUNT(DISTINCT image_tags.image_id) AS count").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Where("image_tags.tag_name IN ? AND images.private = ?", names, false).
+		Group("image_tags.tag_name").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	countMap := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		countMap[c.TagName] = c.Count
+	}
+	result := make([]common.GalleryTagCount, len(tags))
+	for i, tag := range tags {
+		result[i] = common.GalleryTagCount{GalleryTag: tag, Count: countMap[tag.Name]}
+	}
+	return result, nil
+}
+
+// Images 按创建时间倒序分页列出标签下的公开图片
+func (s *galleryService) Images(db *gorm.DB, tag string, page, pageSize int) ([]*common.Image, int64, error) {
+	query := db.Model(&common.Image{}).
+		Where("private = ?", false).
+		Where("id IN (?)", db.Model(&common.ImageTags{}).Select("image_id").Where("tag_name = ?", tag))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var images []*common.Image
+	if err := query.Order("create_time DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&images).Error; err != nil {
+		return nil, 0, err
+	}
+	for _, image := range images {
+		ImageService.FillModel(image)
+	}
+	return images, total, nil
+}
+
+// ThumbnailPath 缩略图的本地缓存路径，缩略图只保存在本地，被清理后按需重新生成
+func (s *galleryService) ThumbnailPath(image *common.Image) string {
+	return utils.DataPath("cache", "thumb", image.Hash[0:2], image.Hash+"-"+strconv.Itoa(common.GALLERY_THUMB_WIDTH)+".webp")
+}
+
+// ThumbnailURL 缩略图地址
+func (s *galleryService) ThumbnailURL(image *common.Image) string {
+	imageHashId, err := vars.HashID.EncodeInt64([]int64{common.ENTITY_TYPE_FILE, image.ID})
+	if err != nil {
+		return ""
+	}
+	return "/t/" + imageHashId + ".webp"
+}
+
+// PageURL 图片查看页地址
+func (s *galleryService) PageURL(image *common.Image) string {
+	imageHashId, err := vars.HashID.EncodeInt64([]int64{common.ENTITY_TYPE_FILE, image.ID})
+	if err != nil {
+		return ""
+	}
+	return "/v/" + imageHashId
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+type hotlinkService struct{}
+
+var HotlinkService = &hotlinkService{}
+
+// Allowed 按防盗链策略检查 Referer，本站页面的请求始终放行
+func (s *hotlinkService) Allowed(referer, selfHost string) bool {
+	policy := vars.HotlinkPolicy
+	if !policy.Enabled {
+		return true
+	}
+	if referer == "" {
+		return policy.AllowEmpty
+	}
+	refURL, err := url.Parse(referer)
+	if err != nil || refURL.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(refURL.Hostname())
+	if host == s.hostname(selfHost) {
+		return true
+	}
+	if baseURL, err := url.Parse(vars.BaseURL); err == nil && host == strings.ToLower(baseURL.Hostname()) {
+		return true
+	}
+	if s.match(policy.Deny, host) {
+		return false
+	}
+	return len(policy.Allow) == 0 || s.match(policy.Allow, host)
+}
+
+func (s *hotlinkService) match(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = s.hostname(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostname 规范化配置中的主机名，兼容带协议和端口的写法
+func (s *hotlinkService) hostname(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	if i := strings.Index(h, "://"); i >= 0 {
+		h = h[i+3:]
+	}
+	h, _, _ = strings.Cut(h, "/")
+	if i := strings.LastIndexByte(h, ':'); i >= 0 && !strings.Contains(h[i:], "]") {
+		h = h[:i]
+	}
+	return h
+}
@@ -3,17 +3,42 @@ package service
 import (
 	"context"
 	"errors"
+	"math/rand/v2"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
 	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
 	"github.com/zjyl1994/momoka/infra/utils"
 	"github.com/zjyl1994/momoka/infra/vars"
 	"gorm.io/gorm"
 )
 
-type imageService struct{}
+type imageService struct {
+	// missCache 缓存不存在的图片 ID，避免对已删除图片的请求反复查询数据库
+	missCache *utils.FreeCacheStorage
+	// tagCache 缓存图片的标签，供图片分发时匹配缓存规则，标签修改时失效
+	tagCache *utils.FreeCacheStorage
+	// randomIDs 按筛选条件缓存随机图片的候选 ID，图片增删改时清空
+	randomLock sync.Mutex
+	randomIDs  map[string]randomIDList
+}
+
+type randomIDList struct {
+	ids    []int64
+	expire time.Time
+}
 
-var ImageService = &imageService{}
+var ImageService = &imageService{
+	missCache: utils.NewFreeCacheStorage(common.IMAGE_MISS_CACHE_SIZE),
+	tagCache:  utils.NewFreeCacheStorage(common.IMAGE_TAG_CACHE_SIZE),
+}
 
 func (s *imageService) Add(db *gorm.DB, image *common.Image) error {
 	image.Tags = lo.Uniq(image.Tags)
@@ -27,13 +52,21 @@ func (s *imageService) Add(db *gorm.DB, image *common.Image) error {
 
 		// If hash doesn't exist, add S3 upload task
 		if hashCount == 0 {
-			err = S3TaskService.Add(tx, []*common.S3Task{
+			tasks := []*common.S3Task{
 				{
 					Action:     common.S3TASK_ACTION_UPLOAD,
 					LocalPath:  image.LocalPath,
 					RemotePath: image.RemotePath,
 				},
-			})
+			}
+			if image.OriginalPath != "" {
+				tasks = append(tasks, &common.S3Task{
+					Action:     common.S3TASK_ACTION_UPLOAD,
+					LocalPath:  s.OriginalLocalPath(image),
+					RemotePath: image.OriginalPath,
+				})
+			}
+			err = S3TaskService.Add(tx, tasks)
 			if err != nil {
 				return err
 			}
@@ -56,6 +89,10 @@ func (s *imageService) Add(db *gorm.DB, image *common.Image) error {
 		return err
 	}
 	go S3TaskService.RunTask()
+	s.missCache.Del(strconv.FormatInt(image.ID, 10))
+	s.tagCache.Del(strconv.FormatInt(image.ID, 10))
+	s.clearRandomCache()
+	DailyStatService.AddUpload(image.FileSize)
 	s.FillModel(image)
 	return nil
 }
@@ -66,11 +103,12 @@ func (s *imageService) Get(db *gorm.DB, id int64) (*common.Image, error) {
 		return nil, err
 	}
 	if image != nil {
-		var tags []string
-		if err := db.Model(&common.ImageTags{}).Where("image_id = ?", id).Pluck("tag_name", &tags).Error; err != nil {
+		if image.Tags, err = s.GetImageTags(db, id); err != nil {
+			return nil, err
+		}
+		if err := ImageCounterService.FillImageStats(db, []*common.Image{image}); err != nil {
 			return nil, err
 		}
-		image.Tags = lo.Uniq(tags)
 	}
 	return image, nil
 }
@@ -87,6 +125,115 @@ func (s *imageService) PureGet(db *gorm.DB, id int64) (*common.Image, error) {
 	return &image, nil
 }
 
+// GetImageTags 查询单张图片的标签
+func (s *imageService) GetImageTags(db *gorm.DB, id int64) ([]string, error) {
+	var tags []string
+	if err := db.Model(&common.ImageTags{}).Where("image_id = ?", id).Pluck("tag_name", &tags).Error; err != nil {
+		return nil, err
+	}
+	return lo.Uniq(tags), nil
+}
+
+// CachedGet 与 PureGet 相同，但会在一段时间内缓存不存在的结果
+func (s *imageService) CachedGet(db *gorm.DB, id int64) (*common.Image, error) {
+	key := strconv.FormatInt(id, 10)
+	if s.missCache.Get(key) != "" {
+		return nil, nil
+	}
+	image, err := s.PureGet(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		s.missCache.Set(key, "1", time.Now().Add(common.IMAGE_MISS_CACHE_TTL))
+	}
+	return image, nil
+}
+
+// CachedTags 与 GetImageTags 相同，但会在一段时间内缓存结果
+func (s *imageService) CachedTags(db *gorm.DB, id int64) ([]string, error) {
+	key := strconv.FormatInt(id, 10)
+	// 缓存值带有前缀，用于区分没有标签和未缓存
+	if value := s.tagCache.Get(key); value != "" {
+		value = strings.TrimPrefix(value, "t")
+		if value == "" {
+			return nil, nil
+		}
+		return strings.Split(value, "\x00"), nil
+	}
+	tags, err := s.GetImageTags(db, id)
+	if err != nil {
+		return nil, err
+	}
+	s.tagCache.Set(key, "t"+strings.Join(tags, "\x00"), time.Now().Add(common.IMAGE_TAG_CACHE_TTL))
+	return tags, nil
+}
+
+// FillSize 补全尺寸未知的图片(如早期上传的图片)，从本地缓存文件读取后写回数据库
+// 读取失败时记为 IMAGE_SIZE_UNREADABLE，之后不再重试
+func (s *imageService) FillSize(db *gorm.DB, image *common.Image) {
+	if (image.Width != 0 && image.Height != 0) || !utils.FileExists(image.LocalPath) {
+		return
+	}
+	width, height, err := utils.ImageSize(image.LocalPath)
+	if err != nil || width == 0 || height == 0 {
+		logrus.Warnf("read size of image %d failed: %v", image.ID, err)
+		width, height = common.IMAGE_SIZE_UNREADABLE, common.IMAGE_SIZE_UNREADABLE
+	}
+	err = db.Model(&common.Image{}).Where("id = ?", image.ID).UpdateColumns(map[string]any{
+		"width":  width,
+		"height": height,
+	}).Error
+	if err != nil {
+		logrus.Errorf("save size of image %d failed: %v", image.ID, err)
+		return
+	}
+	image.Width, image.Height = width, height
+}
+
+// BackfillSize 为本地已有缓存的图片补全尺寸，启动时在后台执行一次
+// 不从 S3 批量下载，没有缓存的图片在查看页或 oEmbed 访问时按需补全
+func (s *imageService) BackfillSize(ctx context.Context) {
+	var lastID int64
+	var count int
+	for ctx.Err() == nil {
+		var images []*common.Image
+		err := vars.Database.Where("id > ? AND (width = 0 OR height = 0)", lastID).
+			Order("id").Limit(100).Find(&images).Error
+		if err != nil {
+			logrus.Errorln("list images without size failed", err)
+			return
+		}
+		if len(images) == 0 {
+			break
+		}
+		for _, image := range images {
+			if ctx.Err() != nil {
+				break
+			}
+			lastID = image.ID
+			s.FillModel(image)
+			if !utils.FileExists(image.LocalPath) {
+				continue
+			}
+			s.FillSize(vars.Database, image)
+			if image.Width > 0 {
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		logrus.Infof("Backfill size of %d image(s)", count)
+	}
+}
+
+// ClearMissCache 清空不存在图片 ID、图片标签和随机图片候选的缓存，用于恢复备份等批量写入之后
+func (s *imageService) ClearMissCache() {
+	s.missCache.Clear()
+	s.tagCache.Clear()
+	s.clearRandomCache()
+}
+
 func (s *imageService) GetByHash(db *gorm.DB, hash string) (*common.Image, error) {
 	var image common.Image
 	if err := db.Where("hash = ?", hash).First(&image).Error; err != nil {
@@ -104,7 +251,7 @@ func (s *imageService) GetByHash(db *gorm.DB, hash string) (*common.Image, error
 	return &image, nil
 }
 
-func (s *imageService) Search(db *gorm.DB, keyword string, page, pageSize int, imageTag string) ([]*common.Image, int64, error) {
+func (s *imageService) Search(db *gorm.DB, keyword string, page, pageSize int, imageTag, sort string) ([]*common.Image, int64, error) {
 	var images []*common.Image
 	var total int64
 
@@ -131,7 +278,7 @@ func (s *imageService) Search(db *gorm.DB, keyword string, page, pageSize int, i
 
 	// Apply pagination and get results
 	offset := (page - 1) * pageSize
-	if err := query.Order("create_time DESC").Offset(offset).Limit(pageSize).Find(&images).Error; err != nil {
+	if err := query.Order(s.searchOrder(sort)).Offset(offset).Limit(pageSize).Find(&images).Error; err != nil {
 		return nil, 0, err
 	}
 
@@ -166,15 +313,124 @@ func (s *imageService) Search(db *gorm.DB, keyword string, page, pageSize int, i
 			}
 			s.FillModel(image)
 		}
+
+		// Load view and bandwidth statistics
+		if err := ImageCounterService.FillImageStats(db, images); err != nil {
+			return nil, 0, err
+		}
 	}
 
 	return images, total, nil
 }
 
+// searchOrder 搜索结果的排序方式，按热度排序时未被访问过的图片排在最后
+func (s *imageService) searchOrder(sort string) string {
+	statColumn := func(column string) string {
+		return "COALESCE((SELECT " + column + " FROM image_stats WHERE image_stats.image_id = images.id), 0) DESC, create_time DESC"
+	}
+	switch sort {
+	case common.IMAGE_SORT_OLDEST:
+		return "create_time ASC"
+	case common.IMAGE_SORT_VIEWS:
+		return statColumn("views")
+	case common.IMAGE_SORT_BANDWIDTH:
+		return statColumn("bandwidth")
+	case common.IMAGE_SORT_LAST_ACCESSED:
+		return statColumn("last_accessed")
+	default:
+		return "create_time DESC"
+	}
+}
+
+// Random 按筛选条件随机选取一张图片，r 为空时使用全局随机数
+// 从缓存的候选 ID 中等概率选取，不受主键空洞和标签分布稀疏的影响
+func (s *imageService) Random(db *gorm.DB, filter common.RandomFilter, r *rand.Rand) (*common.Image, error) {
+	ids, err := s.randomCandidates(db, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var id int64
+	if r != nil {
+		id = ids[r.IntN(len(ids))]
+	} else {
+		id = ids[rand.IntN(len(ids))]
+	}
+
+	var images []*common.Image
+	if err := db.Where("id = ?", id).Limit(1).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	image := images[0]
+	s.FillModel(image)
+	tags, err := s.GetImageTags(db, image.ID)
+	if err != nil {
+		return nil, err
+	}
+	image.Tags = tags
+	return image, nil
+}
+
+// randomCandidates 符合筛选条件的全部图片 ID，按筛选条件缓存一段时间
+func (s *imageService) randomCandidates(db *gorm.DB, filter common.RandomFilter) ([]int64, error) {
+	tags := slices.Clone(filter.Tags)
+	slices.Sort(tags)
+	key := strings.Join(tags, "\x00") + "|" + filter.Visibility + "|" + filter.Orientation
+
+	s.randomLock.Lock()
+	defer s.randomLock.Unlock()
+	if list, ok := s.randomIDs[key]; ok && time.Now().Before(list.expire) {
+		return list.ids, nil
+	}
+
+	query := db.Model(&common.Image{})
+	if len(filter.Tags) > 0 {
+		query = query.Where("id IN (?)",
+			db.Model(&common.ImageTags{}).Select("image_id").Where("tag_name IN ?", filter.Tags),
+		)
+	}
+	switch filter.Visibility {
+	case common.IMAGE_VISIBILITY_PUBLIC:
+		query = query.Where("private = ?", false)
+	case common.IMAGE_VISIBILITY_PRIVATE:
+		query = query.Where("private = ?", true)
+	}
+	// 尺寸未知的图片不参与方向筛选
+	switch filter.Orientation {
+	case common.IMAGE_ORIENTATION_LANDSCAPE:
+		query = query.Where("width > 0 AND width > height")
+	case common.IMAGE_ORIENTATION_PORTRAIT:
+		query = query.Where("width > 0 AND width < height")
+	case common.IMAGE_ORIENTATION_SQUARE:
+		query = query.Where("width > 0 AND width = height")
+	}
+	var ids []int64
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if s.randomIDs == nil || len(s.randomIDs) >= common.RANDOM_CACHE_MAX_FILTERS {
+		s.randomIDs = make(map[string]randomIDList)
+	}
+	s.randomIDs[key] = randomIDList{ids: ids, expire: time.Now().Add(common.RANDOM_CACHE_TTL)}
+	return ids, nil
+}
+
+// clearRandomCache 图片增删或修改标签、可见性后清空随机图片的候选缓存
+func (s *imageService) clearRandomCache() {
+	s.randomLock.Lock()
+	s.randomIDs = nil
+	s.randomLock.Unlock()
+}
+
 func (s *imageService) Delete(db *gorm.DB, id []int64) error {
+	var imagesToDelete []common.Image
 	err := db.Transaction(func(tx *gorm.DB) error {
 		// Get images to be deleted for S3 cleanup
-		var imagesToDelete []common.Image
 		if err := tx.Where("id IN ?", id).Find(&imagesToDelete).Error; err != nil {
 			return err
 		}
@@ -189,6 +445,34 @@ func (s *imageService) Delete(db *gorm.DB, id []int64) error {
 			return err
 		}
 
+		// Delete image statistics
+		if err := ImageCounterService.DeleteImageStats(tx, id); err != nil {
+			return err
+		}
+
+		// Delete image variants together with their remote copies
+		variants, err := ImageVariantService.ListByImage(tx, id)
+		if err != nil {
+			return err
+		}
+		if len(variants) > 0 {
+			// Discarded variants have no remote copy
+			tasks := lo.FilterMap(variants, func(v common.ImageVariant, _ int) (*common.S3Task, bool) {
+				return &common.S3Task{
+					Action:     common.S3TASK_ACTION_DELETE,
+					RemotePath: v.RemotePath,
+				}, v.RemotePath != ""
+			})
+			if len(tasks) > 0 {
+				if err := S3TaskService.Add(tx, tasks); err != nil {
+					return err
+				}
+			}
+			if err := tx.Delete(&common.ImageVariant{}, "image_id IN ?", id).Error; err != nil {
+				return err
+			}
+		}
+
 		// Check if any other images use the same hash and add S3 delete tasks if needed
 		for _, image := range imagesToDelete {
 			var hashCount int64
@@ -209,6 +493,26 @@ func (s *imageService) Delete(db *gorm.DB, id []int64) error {
 					return err
 				}
 			}
+
+			// Delete archived original if no other image refers to it
+			if image.OriginalPath != "" {
+				var originalCount int64
+				err := tx.Model(&common.Image{}).Where("original_path = ?", image.OriginalPath).Count(&originalCount).Error
+				if err != nil {
+					return err
+				}
+				if originalCount == 0 {
+					err = S3TaskService.Add(tx, []*common.S3Task{
+						{
+							Action:     common.S3TASK_ACTION_DELETE,
+							RemotePath: image.OriginalPath,
+						},
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
 		}
 
 		return nil
@@ -217,10 +521,19 @@ func (s *imageService) Delete(db *gorm.DB, id []int64) error {
 		return err
 	}
 	go S3TaskService.RunTask()
+	for _, imageId := range id {
+		s.tagCache.Del(strconv.FormatInt(imageId, 10))
+	}
+	s.clearRandomCache()
+	DailyStatService.AddDelete(lo.SumBy(imagesToDelete, func(image common.Image) int64 {
+		return image.FileSize
+	}))
 	return nil
 }
 
 func (s *imageService) Update(db *gorm.DB, image *common.Image) error {
+	defer s.clearRandomCache()
+	defer s.tagCache.Del(strconv.FormatInt(image.ID, 10))
 	return db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Save(image).Error; err != nil {
 			return err
@@ -274,12 +587,29 @@ func (s *imageService) FillModel(m *common.Image) {
 	}
 }
 
+// SignedURL 生成带签名的图片地址，用于访问私有图片
+func (s *imageService) SignedURL(m *common.Image, expire int64) string {
+	imageHashId := strings.TrimSuffix(path.Base(m.URL), m.ExtName)
+	return m.URL + "?" + SignService.SignQuery(imageHashId, expire)
+}
+
+// OriginalLocalPath 原图归档的本地缓存路径
+func (s *imageService) OriginalLocalPath(m *common.Image) string {
+	return utils.DataPath("cache", m.OriginalPath)
+}
+
 func (s *imageService) Download(m *common.Image) error {
 	s.FillModel(m)
 	if m.RemotePath == "" || m.LocalPath == "" {
 		return errors.New("invalid image")
 	}
-	return StorageService.Download(context.Background(), m.RemotePath, m.LocalPath)
+	err := StorageService.Download(context.Background(), m.RemotePath, m.LocalPath)
+	if err != nil {
+		metrics.ImageFallbackDownloads.WithLabelValues("error").Inc()
+	} else {
+		metrics.ImageFallbackDownloads.WithLabelValues("success").Inc()
+	}
+	return err
 }
 
 func (s *imageService) CountForDashboard() (int64, int64, error) {
@@ -3,12 +3,17 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
 	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type imageCounterService struct {
@@ -17,7 +22,14 @@ type imageCounterService struct {
 	totalBandwidth   atomic.Int64
 	monthlyClick     atomic.Int64
 	monthlyBandwidth atomic.Int64
-	fileSizeSf       utils.SingleFlight[int64]
+	totalBlocked     atomic.Int64
+	monthlyBlocked   atomic.Int64
+	totalLimited     atomic.Int64
+	monthlyLimited   atomic.Int64
+
+	// 单张图片的计数先在内存中聚合，随 Save 一起刷入数据库
+	imageStatLock sync.Mutex
+	imageStats    map[int64]*common.ImageStat
 }
 
 var ImageCounterService = &imageCounterService{}
@@ -30,6 +42,10 @@ func (s *imageCounterService) GetData() common.ClickCtrData {
 		TotalBandwidth:   s.totalBandwidth.Load(),
 		MonthlyClick:     s.monthlyClick.Load(),
 		MonthlyBandwidth: s.monthlyBandwidth.Load(),
+		TotalBlocked:     s.totalBlocked.Load(),
+		MonthlyBlocked:   s.monthlyBlocked.Load(),
+		TotalLimited:     s.totalLimited.Load(),
+		MonthlyLimited:   s.monthlyLimited.Load(),
 	}
 }
 
@@ -39,6 +55,10 @@ func (s *imageCounterService) SetData(data common.ClickCtrData) {
 	s.totalBandwidth.Store(data.TotalBandwidth)
 	s.monthlyClick.Store(data.MonthlyClick)
 	s.monthlyBandwidth.Store(data.MonthlyBandwidth)
+	s.totalBlocked.Store(data.TotalBlocked)
+	s.monthlyBlocked.Store(data.MonthlyBlocked)
+	s.totalLimited.Store(data.TotalLimited)
+	s.monthlyLimited.Store(data.MonthlyLimited)
 }
 
 func (s *imageCounterService) checkMonth() {
@@ -48,25 +68,56 @@ func (s *imageCounterService) checkMonth() {
 		if s.globalYearMonth.CompareAndSwap(loadedYM, currentYM) { // reset monthly counter
 			s.monthlyClick.Store(0)
 			s.monthlyBandwidth.Store(0)
+			s.monthlyBlocked.Store(0)
+			s.monthlyLimited.Store(0)
 			go s.Save(context.Background())
 		}
 	}
 }
-func (s *imageCounterService) Incr(path string) {
-	size, err := s.fileSizeSf.Do(path, func() (int64, error) {
-		return utils.GetFileSize(path)
-	})
-	if err != nil {
-		return
-	}
+
+// Incr 记录一次点击，size 为实际发送的字节数
+func (s *imageCounterService) Incr(imageID, size int64) {
 	s.checkMonth()
 	s.monthlyClick.Add(1)
 	s.monthlyBandwidth.Add(size)
 	s.totalClick.Add(1)
 	s.totalBandwidth.Add(size)
+	DailyStatService.AddHit(size)
+	metrics.ImageHits.Inc()
+	metrics.ImageBytes.Add(float64(size))
+
+	s.imageStatLock.Lock()
+	defer s.imageStatLock.Unlock()
+	if s.imageStats == nil {
+		s.imageStats = make(map[int64]*common.ImageStat)
+	}
+	stat, ok := s.imageStats[imageID]
+	if !ok {
+		stat = &common.ImageStat{ImageID: imageID}
+		s.imageStats[imageID] = stat
+	}
+	stat.Views++
+	stat.Bandwidth += size
+	stat.LastAccessed = time.Now().Unix()
+}
+
+// IncrBlocked 记录一次被防盗链拦截的请求
+func (s *imageCounterService) IncrBlocked() {
+	s.checkMonth()
+	s.monthlyBlocked.Add(1)
+	s.totalBlocked.Add(1)
+}
+
+// IncrLimited 记录一次触发限流的请求
+func (s *imageCounterService) IncrLimited() {
+	s.checkMonth()
+	s.monthlyLimited.Add(1)
+	s.totalLimited.Add(1)
 }
 
 func (s *imageCounterService) Save(ctx context.Context) {
+	s.saveImageStats()
+
 	data := s.GetData()
 	clickCtrJson, err := json.Marshal(data)
 	if err != nil {
@@ -78,3 +129,93 @@ func (s *imageCounterService) Save(ctx context.Context) {
 		return
 	}
 }
+
+// saveImageStats 将内存中的单图计数累加到数据库，失败时放回下次重试
+func (s *imageCounterService) saveImageStats() {
+	s.imageStatLock.Lock()
+	pending := s.imageStats
+	s.imageStats = nil
+	s.imageStatLock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	stats := make([]*common.ImageStat, 0, len(pending))
+	for _, stat := range pending {
+		stats = append(stats, stat)
+	}
+	err := vars.Database.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "image_id"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"views":         gorm.Expr("views + excluded.views"),
+			"bandwidth":     gorm.Expr("bandwidth + excluded.bandwidth"),
+			"last_accessed": gorm.Expr("MAX(last_accessed, excluded.last_accessed)"),
+		}),
+	}).CreateInBatches(stats, 100).Error
+	if err != nil {
+		logrus.Errorln("Save image stats failed", err)
+		s.imageStatLock.Lock()
+		for id, stat := range pending {
+			s.mergeImageStatLocked(id, stat)
+		}
+		s.imageStatLock.Unlock()
+	}
+}
+
+func (s *imageCounterService) mergeImageStatLocked(id int64, stat *common.ImageStat) {
+	if s.imageStats == nil {
+		s.imageStats = make(map[int64]*common.ImageStat)
+	}
+	current, ok := s.imageStats[id]
+	if !ok {
+		s.imageStats[id] = stat
+		return
+	}
+	current.Views += stat.Views
+	current.Bandwidth += stat.Bandwidth
+	current.LastAccessed = max(current.LastAccessed, stat.LastAccessed)
+}
+
+// FillImageStats 填充图片的访问统计，包含尚未刷入数据库的部分
+func (s *imageCounterService) FillImageStats(db *gorm.DB, images []*common.Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+	var stats []common.ImageStat
+	if err := db.Where("image_id IN ?", ids).Find(&stats).Error; err != nil {
+		return err
+	}
+	statMap := make(map[int64]common.ImageStat, len(stats))
+	for _, stat := range stats {
+		statMap[stat.ImageID] = stat
+	}
+
+	s.imageStatLock.Lock()
+	defer s.imageStatLock.Unlock()
+	for _, image := range images {
+		stat := statMap[image.ID]
+		if pending, ok := s.imageStats[image.ID]; ok {
+			stat.Views += pending.Views
+			stat.Bandwidth += pending.Bandwidth
+			stat.LastAccessed = max(stat.LastAccessed, pending.LastAccessed)
+		}
+		image.Views = stat.Views
+		image.Bandwidth = stat.Bandwidth
+		image.LastAccessed = stat.LastAccessed
+	}
+	return nil
+}
+
+// DeleteImageStats 删除图片时清理其访问统计
+func (s *imageCounterService) DeleteImageStats(db *gorm.DB, ids []int64) error {
+	s.imageStatLock.Lock()
+	for _, id := range ids {
+		delete(s.imageStats, id)
+	}
+	s.imageStatLock.Unlock()
+	return db.Delete(&common.ImageStat{}, "image_id IN ?", ids).Error
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+// defaultLinkFormats 未配置链接格式时使用的内置格式
+var defaultLinkFormats = []common.LinkFormat{
+	{Name: "url", Template: `{{.URL}}`},
+	{Name: "markdown", Template: `![{{.Name}}]({{.URL}})`},
+	{Name: "html", Template: `<img src="{{html .URL}}" alt="{{html .Name}}"{{if .Width}} width="{{.Width}}" height="{{.Height}}"{{end}}>`},
+	{Name: "picture", Template: `<picture>{{range .Sources}}<source type="{{.Type}}" srcset="{{html .Srcset}}">{{end}}` +
+		`<img src="{{html .URL}}"{{if .Srcset}} srcset="{{html .Srcset}}"{{end}} alt="{{html .Name}}"{{if .Width}} width="{{.Width}}" height="{{.Height}}"{{end}}></picture>`},
+	{Name: "bbcode", Template: `[img]{{.URL}}[/img]`},
+}
+
+type linkFormatService struct {
+	lock      sync.RWMutex
+	templates []*template.Template
+	widthURL  *template.Template
+	widths    []int
+}
+
+var LinkFormatService = &linkFormatService{}
+
+// Reload 按新的设置重新解析链接格式模板
+func (s *linkFormatService) Reload() {
+	policy := vars.LinkFormat
+	formats := policy.Formats
+	if len(formats) == 0 {
+		formats = defaultLinkFormats
+	}
+	templates := make([]*template.Template, 0, len(formats))
+	for _, format := range formats {
+		t, err := template.New(format.Name).Parse(format.Template)
+		if err != nil {
+			logrus.Errorf("parse link format %s failed: %v", format.Name, err)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	var widthURL *template.Template
+	if len(policy.SrcsetWidths) > 0 {
+		var err error
+		if widthURL, err = template.New("width_url").Parse(policy.WidthURL); err != nil {
+			logrus.Errorln("parse link format width url failed", err)
+		}
+	}
+	widths := slices.Clone(policy.SrcsetWidths)
+	slices.Sort(widths)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.templates = templates
+	s.widthURL = widthURL
+	s.widths = slices.Compact(widths)
+}
+
+// Fill 为图片生成全部链接格式，调用前 image.URL 应已是完整地址
+func (s *linkFormatService) Fill(image *common.Image, baseUrl string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data := common.LinkData{
+		URL:    image.URL,
+		Name:   image.Name,
+		Width:  max(image.Width, 0), // 无法读取尺寸时不输出宽高
+		Height: max(image.Height, 0),
+		Srcset: s.srcset(image.URL, image.Width),
+	}
+	if imageHashId, err := vars.HashID.EncodeInt64([]int64{common.ENTITY_TYPE_FILE, image.ID}); err == nil {
+		data.PageURL = baseUrl + "/v/" + imageHashId
+	}
+	imagePath, imageQuery, _ := strings.Cut(image.URL, "?")
+	if imageQuery != "" {
+		imageQuery = "?" + imageQuery
+	}
+	// AVIF 优先于 WebP，浏览器按 <source> 顺序选择第一个支持的格式
+	for _, format := range []string{common.IMAGE_TYPE_AVIF, common.IMAGE_TYPE_WEBP} {
+		if format == image.ContentType || !slices.Contains(vars.AutoConvFormat, format) {
+			continue
+		}
+		// 签名按 hashid 计算，其它格式的地址可以沿用原地址的查询参数
+		sourceURL := strings.TrimSuffix(imagePath, image.ExtName) + "." + strings.TrimPrefix(format, "image/") + imageQuery
+		source := common.LinkSource{Type: format, URL: sourceURL, Srcset: s.srcset(sourceURL, image.Width)}
+		if source.Srcset == "" {
+			source.Srcset = sourceURL
+		}
+		data.Sources = append(data.Sources, source)
+	}
+
+	image.Links = make([]common.Link, 0, len(s.templates))
+	for _, t := range s.templates {
+		var sb strings.Builder
+		if err := t.Execute(&sb, data); err != nil {
+			logrus.Warnf("render link format %s failed: %v", t.Name(), err)
+			continue
+		}
+		image.Links = append(image.Links, common.Link{Name: t.Name(), Value: sb.String()})
+	}
+}
+
+// srcset 按配置的宽度生成 srcset，只使用小于原图宽度的宽度，最后附加原图
+func (s *linkFormatService) srcset(url string, width int) string {
+	if s.widthURL == nil || width <= 0 {
+		return ""
+	}
+	var items []string
+	for _, w := range s.widths {
+		if w >= width {
+			break
+		}
+		var sb strings.Builder
+		if err := s.widthURL.Execute(&sb, map[string]any{"URL": url, "Width": w}); err != nil {
+			logrus.Warnln("render link format width url failed", err)
+			return ""
+		}
+		items = append(items, sb.String()+" "+strconv.Itoa(w)+"w")
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return strings.Join(append(items, url+" "+strconv.Itoa(width)+"w"), ", ")
+}
@@ -24,6 +24,23 @@ func (s *s3TaskService) Add(db *gorm.DB, task []*common.S3Task) error {
 	return db.CreateInBatches(task, 100).Error
 }
 
+// CountByStatus 按状态统计任务数量
+func (s *s3TaskService) CountByStatus(db *gorm.DB) (map[int]int64, error) {
+	var results []struct {
+		Status int
+		Count  int64
+	}
+	err := db.Model(&common.S3Task{}).Select("status, COUNT(*) AS count").Group("status").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int64, len(results))
+	for _, result := range results {
+		counts[result.Status] = result.Count
+	}
+	return counts, nil
+}
+
 func (s *s3TaskService) getTasks() ([]*common.S3Task, error) {
 	return s.getTaskSingleFlight.Do("waiting_tasks", func() ([]*common.S3Task, error) {
 		var tasks []*common.S3Task
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/zjyl1994/momoka/infra/common"
 	"github.com/zjyl1994/momoka/infra/vars"
@@ -94,3 +96,67 @@ func StringForNotExisting(s string) func() (string, string, error) {
 		return s, s, nil
 	}
 }
+
+// jsonSettings JSON 格式的设置项及其对应的运行时变量
+var jsonSettings = map[string]func(data string, apply bool) error{
+	common.SETTING_KEY_UPLOAD_POLICY:  jsonSetting(&vars.UploadPolicy),
+	common.SETTING_KEY_HOTLINK_POLICY: jsonSetting(&vars.HotlinkPolicy),
+	common.SETTING_KEY_DELIVERY_LIMIT: jsonSetting(&vars.DeliveryLimit, DeliveryLimitService.Reload),
+	common.SETTING_KEY_CACHE_POLICY:   jsonSetting(&vars.CachePolicy),
+	common.SETTING_KEY_LINK_FORMAT:    jsonSetting(&vars.LinkFormat, LinkFormatService.Reload),
+	common.SETTING_KEY_GALLERY:        jsonSetting(&vars.Gallery),
+}
+
+// jsonSetting 设置项实现 Validate 时在校验中一并调用，onApply 在加载到运行时变量后调用
+func jsonSetting[T any](target *T, onApply ...func()) func(data string, apply bool) error {
+	return func(data string, apply bool) error {
+		var v T
+		if data != "" {
+			if err := json.Unmarshal([]byte(data), &v); err != nil {
+				return err
+			}
+		}
+		if validator, ok := any(&v).(interface{ Validate() error }); ok {
+			if err := validator.Validate(); err != nil {
+				return err
+			}
+		}
+		if apply {
+			*target = v
+			for _, fn := range onApply {
+				fn()
+			}
+		}
+		return nil
+	}
+}
+
+// CheckJSON 校验 JSON 设置项内容，不修改运行时变量
+func (s *settingService) CheckJSON(name, data string) error {
+	if fn, ok := jsonSettings[name]; ok {
+		return fn(data, false)
+	}
+	return nil
+}
+
+// ApplyJSON 将 JSON 设置项内容加载到运行时变量
+func (s *settingService) ApplyJSON(name, data string) error {
+	if fn, ok := jsonSettings[name]; ok {
+		return fn(data, true)
+	}
+	return nil
+}
+
+// LoadJSONSettings 从数据库加载全部 JSON 设置项
+func (s *settingService) LoadJSONSettings() error {
+	for name := range jsonSettings {
+		data, err := s.Get(name)
+		if err != nil {
+			return err
+		}
+		if err = s.ApplyJSON(name, data); err != nil {
+			return fmt.Errorf("load setting %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrShareNotFound      = errors.New("share link not found")
+	ErrSharePassword      = errors.New("share link password required")
+	ErrShareWrongPassword = errors.New("share link password incorrect")
+)
+
+type shareService struct{}
+
+var ShareService = &shareService{}
+
+// Add 创建分享链接，password 为明文，空为无需密码
+func (s *shareService) Add(db *gorm.DB, link *common.ShareLink, password string) error {
+	if password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		link.Password = string(hashed)
+	}
+	if err := db.Create(link).Error; err != nil {
+		return err
+	}
+	s.FillModel(link)
+	return nil
+}
+
+func (s *shareService) List(db *gorm.DB) ([]*common.ShareLink, error) {
+	var links []*common.ShareLink
+	if err := db.Order("create_time DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		s.FillModel(link)
+	}
+	return links, nil
+}
+
+func (s *shareService) Revoke(db *gorm.DB, id int64) error {
+	return db.Model(&common.ShareLink{}).Where("id = ?", id).Update("revoked", true).Error
+}
+
+func (s *shareService) Delete(db *gorm.DB, id int64) error {
+	return db.Delete(&common.ShareLink{}, id).Error
+}
+
+// GetByCode 按分享码加载有效的分享链接，已撤销、过期或次数用尽的视为不存在
+func (s *shareService) GetByCode(db *gorm.DB, code string) (*common.ShareLink, error) {
+	ids, err := vars.HashID.DecodeInt64WithError(code)
+	if err != nil || len(ids) != 2 || ids[0] != common.ENTITY_TYPE_SHARE {
+		return nil, ErrShareNotFound
+	}
+	var link common.ShareLink
+	if err := db.First(&link, ids[1]).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if !s.available(&link) {
+		return nil, ErrShareNotFound
+	}
+	s.FillModel(&link)
+	return &link, nil
+}
+
+// Access 校验密码并记录一次访问，只在访客主动查看时调用
+// 链接预览机器人只会发 GET 请求，不会消耗阅后即焚和限次分享的次数
+func (s *shareService) Access(db *gorm.DB, code, password string) (*common.ShareLink, error) {
+	link, err := s.GetByCode(db, code)
+	if err != nil {
+		return nil, err
+	}
+	if link.Password != "" {
+		if password == "" {
+			return link, ErrSharePassword
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password)) != nil {
+			return link, ErrShareWrongPassword
+		}
+	}
+	// 原子地增加访问次数，并发访问时也不会超出次数限制
+	result := db.Model(&common.ShareLink{}).
+		Where("id = ? AND (max_views = 0 OR views < max_views)", link.ID).
+		Update("views", gorm.Expr("views + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrShareNotFound
+	}
+	link.Views++
+	return link, nil
+}
+
+// Images 加载分享链接指向的图片，图片地址为带短期签名的分享内地址，不暴露永久地址
+// 签名有效期不会超过分享链接本身的过期时间
+func (s *shareService) Images(db *gorm.DB, link *common.ShareLink) ([]*common.Image, error) {
+	var images []*common.Image
+	switch link.TargetType {
+	case common.SHARE_TARGET_IMAGE:
+		image, err := ImageService.Get(db, link.ImageID)
+		if err != nil {
+			return nil, err
+		}
+		if image != nil {
+			images = append(images, image)
+		}
+	case common.SHARE_TARGET_TAG:
+		// 分页读取标签下的全部图片
+		const pageSize = 1000
+		for page := 1; ; page++ {
+			pageImages, total, err := ImageService.Search(db, "", page, pageSize, link.TagName, "")
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, pageImages...)
+			if len(pageImages) < pageSize || int64(len(images)) >= total {
+				break
+			}
+		}
+	}
+	expire := time.Now().Unix() + common.SHARE_IMAGE_URL_EXPIRE
+	if link.ExpireTime > 0 {
+		expire = min(expire, link.ExpireTime)
+	}
+	for _, image := range images {
+		image.URL = s.ImageURL(link, image, expire)
+	}
+	return images, nil
+}
+
+// ImageURL 分享内的图片地址，编码了分享和图片 ID，只能在签名有效期内访问
+func (s *shareService) ImageURL(link *common.ShareLink, image *common.Image, expire int64) string {
+	token, err := vars.HashID.EncodeInt64([]int64{common.ENTITY_TYPE_SHARE, link.ID, image.ID})
+	if err != nil {
+		return ""
+	}
+	return link.URL + "/i/" + token + image.ExtName + "?" + SignService.SignQuery("share:"+token, expire)
+}
+
+// GetImage 按分享内的图片地址加载图片，每次都重新检查分享链接是否已撤销或过期
+// 访问次数在查看分享时已经计入，这里不再检查次数
+func (s *shareService) GetImage(db *gorm.DB, code, token string) (*common.Image, error) {
+	codeIds, err := vars.HashID.DecodeInt64WithError(code)
+	if err != nil || len(codeIds) != 2 || codeIds[0] != common.ENTITY_TYPE_SHARE {
+		return nil, ErrShareNotFound
+	}
+	ids, err := vars.HashID.DecodeInt64WithError(token)
+	if err != nil || len(ids) != 3 || ids[0] != common.ENTITY_TYPE_SHARE || ids[1] != codeIds[1] {
+		return nil, ErrShareNotFound
+	}
+	var link common.ShareLink
+	if err := db.First(&link, ids[1]).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if link.Revoked || (link.ExpireTime > 0 && time.Now().Unix() > link.ExpireTime) {
+		return nil, ErrShareNotFound
+	}
+	switch link.TargetType {
+	case common.SHARE_TARGET_IMAGE:
+		if ids[2] != link.ImageID {
+			return nil, ErrShareNotFound
+		}
+	case common.SHARE_TARGET_TAG:
+		var count int64
+		err := db.Model(&common.ImageTags{}).Where("image_id = ? AND tag_name = ?", ids[2], link.TagName).Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrShareNotFound
+		}
+	default:
+		return nil, ErrShareNotFound
+	}
+	image, err := ImageService.CachedGet(db, ids[2])
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		return nil, ErrShareNotFound
+	}
+	return image, nil
+}
+
+func (s *shareService) FillModel(m *common.ShareLink) {
+	m.HasPassword = m.Password != ""
+	code, err := vars.HashID.EncodeInt64([]int64{common.ENTITY_TYPE_SHARE, m.ID})
+	if err == nil {
+		m.Code = code
+		m.URL = "/s/" + code
+	}
+}
+
+func (s *shareService) available(m *common.ShareLink) bool {
+	if m.Revoked {
+		return false
+	}
+	if m.ExpireTime > 0 && time.Now().Unix() > m.ExpireTime {
+		return false
+	}
+	return m.MaxViews == 0 || m.Views < m.MaxViews
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+type signService struct{}
+
+var SignService = &signService{}
+
+// Sign 计算资源在过期时间前有效的签名
+func (s *signService) Sign(resource string, expire int64) string {
+	mac := hmac.New(sha256.New, []byte(vars.Secret))
+	mac.Write([]byte(resource + ":" + strconv.FormatInt(expire, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名及过期时间
+func (s *signService) Verify(resource, expireStr, sig string) bool {
+	if expireStr == "" || sig == "" {
+		return false
+	}
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil || time.Now().Unix() > expire {
+		return false
+	}
+	return hmac.Equal([]byte(s.Sign(resource, expire)), []byte(sig))
+}
+
+// SignQuery 生成带签名和过期时间的查询参数
+func (s *signService) SignQuery(resource string, expire int64) string {
+	q := url.Values{}
+	q.Set("e", strconv.FormatInt(expire, 10))
+	q.Set("s", s.Sign(resource, expire))
+	return q.Encode()
+}
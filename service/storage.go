@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -13,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/sirupsen/logrus"
 	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/metrics"
 	"github.com/zjyl1994/momoka/infra/vars"
 )
 
@@ -21,7 +23,8 @@ var StorageService = &storageService{}
 type storageService struct{}
 
 // Upload 上传文件到S3
-func (s *storageService) Upload(ctx context.Context, diskPath, remotePath, contentType string) error {
+func (s *storageService) Upload(ctx context.Context, diskPath, remotePath, contentType string) (err error) {
+	defer metrics.ObserveS3("upload", time.Now(), &err)
 	// 打开本地文件
 	file, err := os.Open(diskPath)
 	if err != nil {
@@ -49,7 +52,8 @@ func (s *storageService) Upload(ctx context.Context, diskPath, remotePath, conte
 }
 
 // Download 从S3下载文件
-func (s *storageService) Download(ctx context.Context, remotePath, diskPath string) error {
+func (s *storageService) Download(ctx context.Context, remotePath, diskPath string) (err error) {
+	defer metrics.ObserveS3("download", time.Now(), &err)
 	// 构建完整的远程路径
 	fullRemotePath := filepath.Join(vars.S3Config.Prefix, remotePath)
 
@@ -81,11 +85,12 @@ func (s *storageService) Download(ctx context.Context, remotePath, diskPath stri
 	return err
 }
 
-func (s *storageService) Delete(ctx context.Context, remotePath string) error {
+func (s *storageService) Delete(ctx context.Context, remotePath string) (err error) {
+	defer metrics.ObserveS3("delete", time.Now(), &err)
 	// 构建完整的远程路径
 	fullRemotePath := filepath.Join(vars.S3Config.Prefix, remotePath)
 	// 删除S3对象
-	_, err := vars.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	_, err = vars.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(vars.S3Config.Bucket),
 		Key:    aws.String(fullRemotePath),
 	})
@@ -95,7 +100,8 @@ func (s *storageService) Delete(ctx context.Context, remotePath string) error {
 	return nil
 }
 
-func (s *storageService) List(ctx context.Context, prefix string) ([]common.FileInfo, error) {
+func (s *storageService) List(ctx context.Context, prefix string) (_ []common.FileInfo, err error) {
+	defer metrics.ObserveS3("list", time.Now(), &err)
 	// Construct full remote path with S3 prefix
 	fullPrefix := filepath.Join(vars.S3Config.Prefix, prefix)
 
@@ -141,7 +147,8 @@ func (s *storageService) List(ctx context.Context, prefix string) ([]common.File
 }
 
 // UploadFromMem uploads data from memory to S3
-func (s *storageService) UploadFromMem(ctx context.Context, data []byte, remotePath, contentType string) error {
+func (s *storageService) UploadFromMem(ctx context.Context, data []byte, remotePath, contentType string) (err error) {
+	defer metrics.ObserveS3("upload", time.Now(), &err)
 	// Construct full remote path
 	fullRemotePath := filepath.Join(vars.S3Config.Prefix, remotePath)
 
@@ -162,7 +169,8 @@ func (s *storageService) UploadFromMem(ctx context.Context, data []byte, remoteP
 }
 
 // DownloadToMem downloads data from S3 to memory
-func (s *storageService) DownloadToMem(ctx context.Context, remotePath string) ([]byte, error) {
+func (s *storageService) DownloadToMem(ctx context.Context, remotePath string) (_ []byte, err error) {
+	defer metrics.ObserveS3("download", time.Now(), &err)
 	// Construct full remote path
 	fullRemotePath := filepath.Join(vars.S3Config.Prefix, remotePath)
 
@@ -180,7 +188,8 @@ func (s *storageService) DownloadToMem(ctx context.Context, remotePath string) (
 	return io.ReadAll(resp.Body)
 }
 
-func (s *storageService) DeleteObjs(ctx context.Context, remotePaths []string) ([]string, error) {
+func (s *storageService) DeleteObjs(ctx context.Context, remotePaths []string) (_ []string, err error) {
+	defer metrics.ObserveS3("delete_objects", time.Now(), &err)
 	// 存储删除失败的key
 	var failedKeys []string
 
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+)
+
+var (
+	ErrTusNotFound       = errors.New("upload not found")
+	ErrTusOffsetMismatch = errors.New("upload offset mismatch")
+	ErrTusExceedsLength  = errors.New("upload exceeds declared length")
+	ErrTusInvalidMeta    = errors.New("invalid upload metadata")
+	ErrTusInterrupted    = errors.New("upload interrupted")
+)
+
+type tusService struct {
+	locks sync.Map // 上传 ID -> *sync.Mutex，同一上传的写入需要串行
+}
+
+var TusService = &tusService{}
+
+// Create 创建新的断点续传上传，rawMeta 为 Upload-Metadata 请求头
+func (s *tusService) Create(length int64, rawMeta string) (*common.TusUpload, error) {
+	metadata, err := parseTusMetadata(rawMeta)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	upload := &common.TusUpload{
+		ID:         utils.RandStr(32),
+		Length:     length,
+		Metadata:   metadata,
+		RawMeta:    rawMeta,
+		CreateTime: now.Unix(),
+		ExpireTime: now.Add(common.TUS_UPLOAD_EXPIRE).Unix(),
+	}
+	if err = os.MkdirAll(s.dir(), 0755); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(s.dataPath(upload.ID), nil, 0644); err != nil {
+		return nil, err
+	}
+	if err = s.save(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get 加载上传状态，过期的上传视为不存在
+func (s *tusService) Get(id string) (*common.TusUpload, error) {
+	if !validTusID(id) {
+		return nil, ErrTusNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrTusNotFound
+		}
+		return nil, err
+	}
+	var upload common.TusUpload
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > upload.ExpireTime {
+		return nil, ErrTusNotFound
+	}
+	return &upload, nil
+}
+
+// Append 从 offset 处写入请求体，全部接收后按上传流程入库
+// 读取中断时保留已写入的部分并返回 ErrTusInterrupted，客户端可以从新的偏移继续
+// 入库失败时上传会被删除，返回入库的错误
+func (s *tusService) Append(id string, offset int64, r io.Reader) (*common.TusUpload, error) {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrTusOffsetMismatch
+	}
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// 按偏移写入，状态保存失败时重传同一段数据会覆盖而不是重复追加
+	n, copyErr := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(r, upload.Length-offset))
+	if closeErr := file.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil && n == upload.Length-offset {
+		// 超出声明长度的数据不写入，已写入的部分也不计入进度
+		if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+			return upload, ErrTusExceedsLength
+		}
+	}
+	if n > 0 {
+		upload.Offset += n
+		// 仍在接收数据的上传顺延过期时间
+		upload.ExpireTime = time.Now().Add(common.TUS_UPLOAD_EXPIRE).Unix()
+	}
+	if copyErr != nil {
+		if err = s.save(upload); err != nil {
+			return nil, err
+		}
+		return upload, fmt.Errorf("%w: %v", ErrTusInterrupted, copyErr)
+	}
+	if upload.Offset == upload.Length && upload.ImageID == 0 {
+		if err = s.complete(upload); err != nil {
+			s.remove(id)
+			return nil, err
+		}
+	}
+	if err = s.save(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Delete 终止并删除上传
+func (s *tusService) Delete(id string) error {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+// Cleanup 清理过期的上传
+func (s *tusService) Cleanup(ctx context.Context) {
+	entries, err := os.ReadDir(s.dir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorln("list tus uploads failed", err)
+		}
+		return
+	}
+	var count int
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || !validTusID(id) {
+			continue
+		}
+		if _, err := s.Get(id); errors.Is(err, ErrTusNotFound) {
+			lock := s.lock(id)
+			lock.Lock()
+			s.remove(id)
+			lock.Unlock()
+			count++
+		}
+	}
+	if count > 0 {
+		logrus.Infof("Cleanup %d expired upload(s)", count)
+	}
+}
+
+// complete 将上传完成的文件交给上传流程入库
+func (s *tusService) complete(upload *common.TusUpload) error {
+	data, err := os.ReadFile(s.dataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	data = data[:upload.Length]
+
+	// 优先按文件内容判断类型，无法识别时使用客户端声明的类型
+	contentType := utils.DetectImageType(data)
+	if contentType == "" {
+		contentType = upload.Metadata["filetype"]
+	}
+	filename := upload.Metadata["filename"]
+	if filepath.Ext(filename) == "" {
+		filename += utils.ImageExtName(contentType)
+	}
+	var tags []string
+	for _, tag := range strings.Split(upload.Metadata["tags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	private, _ := strconv.ParseBool(upload.Metadata["private"])
+
+	image, _, err := UploadService.Ingest(&common.UploadFile{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+		Remark:      upload.Metadata["remark"],
+		Tags:        tags,
+		Private:     private,
+	})
+	if err != nil {
+		return err
+	}
+	upload.ImageID = image.ID
+	// 入库后只保留状态文件，供客户端查询结果直到过期
+	if err = os.Remove(s.dataPath(upload.ID)); err != nil {
+		logrus.Warnln("remove completed upload data failed", err)
+	}
+	return nil
+}
+
+func (s *tusService) save(upload *common.TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免中断时留下不完整的状态
+	tmpPath := s.infoPath(upload.ID) + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.infoPath(upload.ID))
+}
+
+func (s *tusService) remove(id string) {
+	os.Remove(s.dataPath(id))
+	os.Remove(s.infoPath(id))
+	s.locks.Delete(id)
+}
+
+func (s *tusService) lock(id string) *sync.Mutex {
+	lock, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func (s *tusService) dir() string {
+	return utils.DataPath("tus")
+}
+
+func (s *tusService) infoPath(id string) string {
+	return filepath.Join(s.dir(), id+".info")
+}
+
+func (s *tusService) dataPath(id string) string {
+	return filepath.Join(s.dir(), id+".bin")
+}
+
+// validTusID 上传 ID 只包含字母和数字，防止路径穿越
+func validTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTusMetadata 解析 Upload-Metadata，格式为逗号分隔的 "键 base64值"，值可以省略
+func parseTusMetadata(raw string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || key == "" {
+			return nil, ErrTusInvalidMeta
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+)
+
+var (
+	ErrNotImage         = errors.New("only image files are allowed")
+	ErrFileTooLarge     = errors.New("file size exceeds limit")
+	ErrPrivateDuplicate = errors.New("the same image already exists as a private image")
+)
+
+type uploadService struct {
+	hashLocks [256]sync.Mutex // 按哈希首字节分段加锁，相同内容的并发上传串行去重
+}
+
+var UploadService = &uploadService{}
+
+// Ingest 上传文件入库：应用上传策略、计算哈希、去重并保存
+// 返回的 created 为 false 表示命中了已有图片
+func (s *uploadService) Ingest(file *common.UploadFile) (image *common.Image, created bool, err error) {
+	if !strings.HasPrefix(file.ContentType, "image/") {
+		return nil, false, ErrNotImage
+	}
+	if len(file.Data) > common.MAX_IMAGE_SIZE {
+		return nil, false, ErrFileTooLarge
+	}
+
+	extName := filepath.Ext(file.Filename)
+	name := strings.TrimSuffix(file.Filename, extName)
+
+	// 在计算哈希前应用上传策略
+	result, err := utils.ProcessUpload(file.Data, file.ContentType, extName, vars.UploadPolicy)
+	if err != nil {
+		logrus.Warnf("apply upload policy to %s failed, keep original: %v", file.Filename, err)
+	}
+
+	hashBytes := utils.SHA256Hash(result.Data)
+	hash := hex.EncodeToString(hashBytes)
+	// 查重和入库之间加锁，否则同一批次中内容相同的文件会因唯一索引冲突而失败
+	lock := &s.hashLocks[hashBytes[0]]
+	lock.Lock()
+	defer lock.Unlock()
+	existingImage, err := ImageService.GetByHash(vars.Database, hash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existingImage != nil && existingImage.Private && file.RejectPrivateDuplicate {
+		return nil, false, ErrPrivateDuplicate
+	}
+	if existingImage != nil {
+		// Hash already exists, update existing record like ImageUpdateHandler
+		image = existingImage
+		if name != "" {
+			image.Name = name
+		}
+		if file.Remark != "" {
+			image.Remark = file.Remark
+		}
+		if len(file.Tags) > 0 {
+			image.Tags = file.Tags
+		}
+		if file.Private {
+			image.Private = true
+		}
+		if image.SourceURL == "" {
+			image.SourceURL = file.SourceURL
+		}
+		if err := ImageService.Update(vars.Database, image); err != nil {
+			return nil, false, err
+		}
+	} else {
+		image, err = s.create(file, name, extName, hash, result)
+		if err != nil {
+			return nil, false, err
+		}
+		created = true
+	}
+
+	// Async convert to avif/webp
+	if lo.Contains(vars.AutoConvFormat, common.IMAGE_TYPE_AVIF) {
+		vars.ImageConverter.Convert(image.LocalPath, utils.ChangeExtName(image.LocalPath, "avif"))
+	}
+	if lo.Contains(vars.AutoConvFormat, common.IMAGE_TYPE_WEBP) {
+		vars.ImageConverter.Convert(image.LocalPath, utils.ChangeExtName(image.LocalPath, "webp"))
+	}
+	return image, created, nil
+}
+
+// IngestURL 抓取远程图片后按上传流程入库，file 中的文件名、内容和来源由抓取结果填充
+func (s *uploadService) IngestURL(ctx context.Context, rawURL string, file common.UploadFile) (*common.Image, bool, error) {
+	fetcher := &utils.RemoteFetcher{
+		MaxSize:      common.MAX_IMAGE_SIZE,
+		Timeout:      common.FETCH_TIMEOUT,
+		MaxRedirects: common.FETCH_MAX_REDIRECTS,
+		AllowPrivate: vars.FetchAllowPrivate,
+	}
+	remote, err := fetcher.Fetch(ctx, rawURL)
+	if err != nil {
+		if errors.Is(err, utils.ErrFetchTooLarge) {
+			return nil, false, ErrFileTooLarge
+		}
+		return nil, false, err
+	}
+	// 按文件内容判断类型，不信任远程响应头和地址中的扩展名
+	contentType := utils.DetectImageType(remote.Data)
+	extName := utils.ImageExtName(contentType)
+	if extName == "" {
+		return nil, false, ErrNotImage
+	}
+	file.Filename = strings.TrimSuffix(remote.Filename, filepath.Ext(remote.Filename)) + extName
+	file.ContentType = contentType
+	file.Data = remote.Data
+	file.SourceURL = rawURL
+	return s.Ingest(&file)
+}
+
+func (s *uploadService) create(file *common.UploadFile, name, extName, hash string, result utils.UploadResult) (*common.Image, error) {
+	image := &common.Image{
+		Name:        name,
+		ExtName:     result.ExtName,
+		ContentType: result.ContentType,
+		Hash:        hash,
+		FileSize:    int64(len(result.Data)),
+		Width:       result.Width,
+		Height:      result.Height,
+		Remark:      file.Remark,
+		Tags:        file.Tags,
+		Private:     file.Private,
+		SourceURL:   file.SourceURL,
+	}
+	ImageService.FillModel(image)
+
+	if err := utils.SaveFile(image.LocalPath, result.Data); err != nil {
+		return nil, err
+	}
+	// 图片被上传策略修改过时，按需归档原图
+	if result.Changed && vars.UploadPolicy.KeepOriginal {
+		image.OriginalPath = "original/" + hex.EncodeToString(utils.SHA256Hash(file.Data)) + extName
+		if err := utils.SaveFile(ImageService.OriginalLocalPath(image), file.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ImageService.Add(vars.Database, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type imageVariantService struct {
+	downloadSf utils.SingleFlight[string]
+}
+
+var ImageVariantService = &imageVariantService{}
+
+// OnConverted 转换器回调，记录衍生版本并上传到对象存储
+func (s *imageVariantService) OnConverted(inputFile, outFile string) {
+	imageHash := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	image, err := ImageService.GetByHash(vars.Database, imageHash)
+	if err != nil {
+		logrus.Errorln("load image for variant failed", err)
+		return
+	}
+	if image == nil {
+		return
+	}
+	// 重新生成或丢弃的版本不能再使用内存中的旧内容
+	CacheService.Evict(outFile)
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		logrus.Errorln("read variant file failed", err)
+		return
+	}
+	variant := &common.ImageVariant{
+		ImageID:  image.ID,
+		Format:   mime.TypeByExtension(filepath.Ext(outFile)),
+		FileSize: int64(len(data)),
+		Hash:     hex.EncodeToString(utils.SHA256Hash(data)),
+	}
+	if variant.FileSize > image.FileSize*int64(100-vars.VariantMinSaving)/100 {
+		// 转换结果不够小，记录决定并删除本地文件，之后直接使用原图
+		logrus.Infof("variant %s of image %d is not smaller than original (%d >= %d), discarded", variant.Format, image.ID, variant.FileSize, image.FileSize)
+		variant.Discarded = true
+		if err = os.Remove(outFile); err != nil {
+			logrus.Errorln("remove discarded variant failed", err)
+		}
+	} else {
+		variant.RemotePath = s.RemotePath(image, variant.TransformKey, filepath.Ext(outFile))
+	}
+	if err = s.Add(vars.Database, variant, outFile); err != nil {
+		logrus.Errorln("save variant failed", err)
+		return
+	}
+	DailyStatService.AddConversion()
+}
+
+// Add 保存衍生版本记录，并添加上传任务；被丢弃的版本会清理之前上传的副本
+func (s *imageVariantService) Add(db *gorm.DB, variant *common.ImageVariant, localPath string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existing, err := s.Get(tx, variant.ImageID, variant.Format, variant.TransformKey)
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "image_id"}, {Name: "format"}, {Name: "transform_key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"file_size", "hash", "remote_path", "discarded", "update_time"}),
+		}).Create(variant).Error
+		if err != nil {
+			return err
+		}
+		if variant.Discarded {
+			if existing == nil || existing.RemotePath == "" {
+				return nil
+			}
+			return S3TaskService.Add(tx, []*common.S3Task{
+				{
+					Action:     common.S3TASK_ACTION_DELETE,
+					RemotePath: existing.RemotePath,
+				},
+			})
+		}
+		return S3TaskService.Add(tx, []*common.S3Task{
+			{
+				Action:     common.S3TASK_ACTION_UPLOAD,
+				LocalPath:  localPath,
+				RemotePath: variant.RemotePath,
+			},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	go S3TaskService.RunTask()
+	return nil
+}
+
+func (s *imageVariantService) Get(db *gorm.DB, imageID int64, format, transformKey string) (*common.ImageVariant, error) {
+	var variant common.ImageVariant
+	err := db.Where("image_id = ? AND format = ? AND transform_key = ?", imageID, format, transformKey).First(&variant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &variant, nil
+}
+
+func (s *imageVariantService) ListByImage(db *gorm.DB, imageIDs []int64) ([]common.ImageVariant, error) {
+	var variants []common.ImageVariant
+	if err := db.Where("image_id IN ?", imageIDs).Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// RemotePath 衍生版本在对象存储中的路径
+func (s *imageVariantService) RemotePath(image *common.Image, transformKey, extName string) string {
+	name := image.Hash
+	if transformKey != "" {
+		name += "_" + transformKey
+	}
+	return "variant/" + name + extName
+}
+
+// ParseVariantMinSaving 解析衍生版本最小节省比例设置，无效时使用默认值
+func ParseVariantMinSaving(s string) int {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v >= 100 {
+		return common.DEFAULT_VARIANT_MIN_SAVING
+	}
+	return v
+}
+
+// Download 本地缓存缺失时从对象存储取回衍生版本
+func (s *imageVariantService) Download(variant *common.ImageVariant, localPath string) error {
+	_, err := s.downloadSf.Do(localPath, func() (string, error) {
+		return localPath, StorageService.Download(context.Background(), variant.RemotePath, localPath)
+	})
+	return err
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zjyl1994/momoka/infra/common"
+	"github.com/zjyl1994/momoka/infra/utils"
+	"github.com/zjyl1994/momoka/infra/vars"
+	"gorm.io/gorm"
+)
+
+var ErrVariantJobRunning = errors.New("variant job is already running")
+
+type variantJobService struct {
+	lock   sync.Mutex
+	state  common.VariantJobState
+	cancel context.CancelFunc
+}
+
+var VariantJobService = &variantJobService{}
+
+// Init 加载上次的任务进度，未完成的任务自动继续
+func (s *variantJobService) Init() error {
+	data, err := SettingService.Get(common.SETTING_KEY_VARIANT_JOB)
+	if err != nil {
+		return err
+	}
+	if data == "" {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = json.Unmarshal([]byte(data), &s.state); err != nil {
+		return err
+	}
+	if s.state.Status == common.VARIANT_JOB_STATUS_RUNNING {
+		logrus.Infof("Resume variant job from image %d", s.state.LastID)
+		s.startLocked()
+	}
+	return nil
+}
+
+func (s *variantJobService) GetState() common.VariantJobState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state
+}
+
+// Start 按筛选条件开始新的批量生成任务
+func (s *variantJobService) Start(filter common.VariantJobFilter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		return ErrVariantJobRunning
+	}
+	s.state = common.VariantJobState{
+		Status:    common.VARIANT_JOB_STATUS_RUNNING,
+		Filter:    filter,
+		StartTime: time.Now().Unix(),
+	}
+	if err := s.query(vars.Database, filter, 0).Count(&s.state.Total).Error; err != nil {
+		return err
+	}
+	s.startLocked()
+	return nil
+}
+
+// Resume 从上次中断的位置继续任务
+func (s *variantJobService) Resume() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		return ErrVariantJobRunning
+	}
+	if s.state.Status != common.VARIANT_JOB_STATUS_PAUSED && s.state.Status != common.VARIANT_JOB_STATUS_FAILED {
+		return errors.New("no paused variant job")
+	}
+	s.state.Status = common.VARIANT_JOB_STATUS_RUNNING
+	s.state.Error = ""
+	s.startLocked()
+	return nil
+}
+
+// Pause 暂停任务，进度保留以便继续
+func (s *variantJobService) Pause() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+func (s *variantJobService) startLocked() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.saveLocked()
+	go s.run(ctx)
+}
+
+func (s *variantJobService) run(ctx context.Context) {
+	err := s.process(ctx)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cancel = nil
+	switch {
+	case errors.Is(err, context.Canceled):
+		s.state.Status = common.VARIANT_JOB_STATUS_PAUSED
+	case err != nil:
+		logrus.Errorln("variant job failed", err)
+		s.state.Status = common.VARIANT_JOB_STATUS_FAILED
+		s.state.Error = err.Error()
+	default:
+		s.state.Status = common.VARIANT_JOB_STATUS_FINISHED
+		s.state.EndTime = time.Now().Unix()
+		logrus.Infof("Variant job finished, processed %d, converted %d, failed %d", s.state.Processed, s.state.Converted, s.state.Failed)
+	}
+	s.saveLocked()
+}
+
+func (s *variantJobService) process(ctx context.Context) error {
+	filter := s.GetState().Filter
+	formats := filter.Formats
+	if len(formats) == 0 {
+		formats = append([]string(nil), vars.AutoConvFormat...)
+	}
+	interval := time.Duration(filter.IntervalMs) * time.Millisecond
+
+	for {
+		var images []*common.Image
+		err := s.query(vars.Database, filter, s.GetState().LastID).Order("id").Limit(50).Find(&images).Error
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+		for _, image := range images {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			converted, err := s.processImage(image, formats, filter.Force)
+			if err != nil {
+				logrus.Errorf("variant job process image %d failed: %v", image.ID, err)
+			}
+
+			s.lock.Lock()
+			s.state.LastID = image.ID
+			s.state.Processed++
+			s.state.Converted += int64(converted)
+			if err != nil {
+				s.state.Failed++
+			}
+			s.saveLocked()
+			s.lock.Unlock()
+
+			if interval > 0 {
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+func (s *variantJobService) processImage(image *common.Image, formats []string, force bool) (int, error) {
+	ImageService.FillModel(image)
+	var converted int
+	for _, format := range formats {
+		if format == image.ContentType {
+			continue
+		}
+		if !force {
+			variant, err := ImageVariantService.Get(vars.Database, image.ID, format, "")
+			if err != nil {
+				return converted, err
+			}
+			if variant != nil {
+				continue
+			}
+		}
+		targetPath := utils.ChangeExtName(image.LocalPath, strings.TrimPrefix(format, "image/"))
+		if utils.FileExists(targetPath) {
+			if !force {
+				// 本地已有转换结果但未入库，直接登记
+				ImageVariantService.OnConverted(image.LocalPath, targetPath)
+				converted++
+				continue
+			}
+			if err := os.Remove(targetPath); err != nil {
+				return converted, err
+			}
+			CacheService.Evict(targetPath)
+		}
+		if !utils.FileExists(image.LocalPath) {
+			if err := ImageService.Download(image); err != nil {
+				return converted, err
+			}
+		}
+		if err := vars.ImageConverter.ConvertSync(image.LocalPath, targetPath); err != nil {
+			return converted, err
+		}
+		converted++
+	}
+	return converted, nil
+}
+
+func (s *variantJobService) query(db *gorm.DB, filter common.VariantJobFilter, afterID int64) *gorm.DB {
+	query := db.Model(&common.Image{}).Where("id > ?", afterID)
+	if len(filter.Tags) > 0 {
+		query = query.Where("id IN (?)",
+			db.Model(&common.ImageTags{}).Select("image_id").Where("tag_name IN ?", filter.Tags),
+		)
+	}
+	if filter.StartTime > 0 {
+		query = query.Where("create_time >= ?", filter.StartTime)
+	}
+	if filter.EndTime > 0 {
+		query = query.Where("create_time <= ?", filter.EndTime)
+	}
+	return query
+}
+
+func (s *variantJobService) saveLocked() {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		logrus.Errorln("marshal variant job state failed", err)
+		return
+	}
+	if err = SettingService.Set(common.SETTING_KEY_VARIANT_JOB, string(data)); err != nil {
+		logrus.Errorln("save variant job state failed", err)
+	}
+}